@@ -0,0 +1,58 @@
+package deadlockreport
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/13rac1/pg-deadlocks/lockanalysis"
+	"github.com/13rac1/pg-deadlocks/lockverify"
+)
+
+// PrintAnalysis prints the locks predicted for every statement.
+func PrintAnalysis(statements []lockanalysis.Statement) {
+	for _, s := range statements {
+		fmt.Println(oneLine(s.SQL))
+		for _, l := range s.Tables {
+			fmt.Printf("  %s\n", l)
+		}
+		for _, l := range s.Rows {
+			fmt.Printf("  rows %s\n", l)
+		}
+		switch {
+		case !s.Known:
+			fmt.Println("  locks unknown")
+		case len(s.Tables) == 0:
+			fmt.Println("  no table locks")
+		}
+		if !s.Transactional {
+			fmt.Println("  cannot run inside a transaction block")
+		}
+	}
+}
+
+// PrintLockChecks prints the predicted and observed locks of the statements
+// run by lockverify.Verify, and the number of correct predictions.
+func PrintLockChecks(checks []lockverify.Check) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	ok := 0
+	for _, c := range checks {
+		status := "ok"
+		switch {
+		case c.Err != nil:
+			status = "error: " + c.Err.Error()
+		case c.Skipped:
+			status = "skipped, not transactional"
+		case !c.Statement.Known:
+			status = "unknown statement"
+		case len(c.Mismatches) > 0:
+			status = "mismatch: " + strings.Join(c.Mismatches, "; ")
+		default:
+			ok++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", oneLine(c.Statement.SQL), c.Statement, status)
+	}
+	w.Flush()
+	fmt.Printf("%d of %d predictions match pg_locks\n", ok, len(checks))
+}
@@ -0,0 +1,30 @@
+package deadlockreport
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/13rac1/pg-deadlocks/scenario"
+)
+
+// PrintComparison prints the stats returned by scenario.Compare.
+func PrintComparison(s scenario.Scenario, stats []scenario.VariantStats) {
+	fmt.Printf("\n%s: %s\n", s.Name, s.Description)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  variant\tdeadlocks\toutcomes\tmean\tmax\t")
+	for _, v := range stats {
+		name := strings.TrimPrefix(v.Name, s.Name)
+		if name == "" {
+			name = "original"
+		}
+		fmt.Fprintf(w, "  %s\t%d/%d\t%s\t%s\t%s\t\n", name, v.Deadlocks, v.Runs,
+			v.OutcomeCounts(), v.Mean().Round(time.Millisecond), v.Max.Round(time.Millisecond))
+	}
+	w.Flush()
+	for _, f := range s.Fixes {
+		fmt.Printf("  %s: %s\n", strings.TrimPrefix(f.Name, s.Name), f.Description)
+	}
+}
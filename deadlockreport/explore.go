@@ -0,0 +1,44 @@
+package deadlockreport
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/13rac1/pg-deadlocks/scenario"
+)
+
+// PrintExploration prints the scripts of the sessions, the outcome of every
+// schedule returned by scenario.Explore and how many schedules of each
+// outcome were found. The first schedule of every outcome is printed in full.
+func PrintExploration(scripts [][]string, results []scenario.Result, pruned int) {
+	for s, script := range scripts {
+		for i, query := range script {
+			fmt.Printf("s%d.%d %s\n", s, i+1, oneLine(query))
+		}
+	}
+
+	counts := map[scenario.Outcome]int{}
+	for _, r := range results {
+		if counts[r.Outcome] == 0 {
+			fmt.Println()
+			PrintResult(r, false)
+		}
+		counts[r.Outcome]++
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.Scenario, r.Outcome, r.Duration.Round(time.Millisecond))
+	}
+	w.Flush()
+
+	fmt.Printf("\n%d schedules run, %d equivalent schedules pruned\n", len(results), pruned)
+	for o := scenario.OutcomeAppDeadlock; o >= scenario.OutcomeOK; o-- {
+		if counts[o] > 0 {
+			fmt.Printf("  %s: %d\n", o, counts[o])
+		}
+	}
+}
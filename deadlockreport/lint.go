@@ -0,0 +1,46 @@
+package deadlockreport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/13rac1/pg-deadlocks/lockanalysis"
+)
+
+// WriteFindings writes migration lint findings in format: "text" writes
+// file:line: rule: message lines, "json" an array of findings and "github"
+// GitHub Actions workflow commands which annotate the lines.
+func WriteFindings(out io.Writer, findings []lockanalysis.Finding, format string) error {
+	switch format {
+	case "text":
+		for _, f := range findings {
+			fmt.Fprintln(out, f)
+		}
+	case "json":
+		if findings == nil {
+			findings = []lockanalysis.Finding{}
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(findings)
+	case "github":
+		for _, f := range findings {
+			fmt.Fprintf(out, "::warning file=%s,line=%d,title=%s::%s\n",
+				githubEscape(f.File, true), f.Line, f.Rule, githubEscape(f.Message, false))
+		}
+	default:
+		return fmt.Errorf("unknown format %q, use text, json or github", format)
+	}
+	return nil
+}
+
+// githubEscape escapes the data, or a property, of a workflow command.
+func githubEscape(s string, property bool) string {
+	s = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+	if property {
+		s = strings.NewReplacer(":", "%3A", ",", "%2C").Replace(s)
+	}
+	return s
+}
@@ -0,0 +1,40 @@
+package deadlockreport
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/13rac1/pg-deadlocks/scenario"
+)
+
+// PrintLockMatrix prints which pairs of lock modes deadlock, rows are the
+// lock held by both sessions and columns the lock they upgrade to.
+func PrintLockMatrix(cells scenario.LockMatrix) error {
+	fmt.Println("rows: lock held by both sessions, columns: lock upgraded to")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprint(w, "\t")
+	for _, upgrade := range scenario.LockModes {
+		fmt.Fprintf(w, "%s\t", lockModeAbbrev(upgrade))
+	}
+	fmt.Fprintln(w)
+	for _, initial := range scenario.LockModes {
+		fmt.Fprintf(w, "%s\t", initial)
+		for _, upgrade := range scenario.LockModes {
+			fmt.Fprintf(w, "%s\t", cells[initial][upgrade])
+		}
+		fmt.Fprintln(w)
+	}
+	return w.Flush()
+}
+
+// lockModeAbbrev shortens a lock mode to its initials, e.g. SHARE ROW
+// EXCLUSIVE to SRE, to keep the matrix narrow.
+func lockModeAbbrev(mode string) string {
+	var abbrev string
+	for _, word := range strings.Fields(mode) {
+		abbrev += word[:1]
+	}
+	return abbrev
+}
@@ -0,0 +1,44 @@
+package deadlockreport
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/13rac1/pg-deadlocks/lockanalysis"
+	"github.com/13rac1/pg-deadlocks/scenario"
+)
+
+// PrintInversions prints the lock order inversions predicted between scripts
+// a and b, with the statements taking the locks. results are the outcomes of
+// running the schedules which should deadlock, nil when they were not run.
+func PrintInversions(a, b lockanalysis.Migration, inversions []lockanalysis.Inversion, results []scenario.Result) {
+	if len(inversions) == 0 {
+		fmt.Printf("no lock order inversions between %s and %s\n", a.Name, b.Name)
+		return
+	}
+	statement := func(m lockanalysis.Migration, l lockanalysis.Acquisition) string {
+		return fmt.Sprintf("%s:%d: %s", m.Name, m.Lines[l.Statement], oneLine(withoutComments(m.Statements[l.Statement])))
+	}
+	for i, inv := range inversions {
+		fmt.Printf("possible deadlock %d:\n", i+1)
+		fmt.Printf("  A holds %s taken by %s\n", inv.HeldA, statement(a, inv.HeldA))
+		fmt.Printf("  B holds %s taken by %s\n", inv.HeldB, statement(b, inv.HeldB))
+		fmt.Printf("  A waits for %s at %s\n", inv.WantA, statement(a, inv.WantA))
+		fmt.Printf("  B waits for %s at %s\n", inv.WantB, statement(b, inv.WantB))
+		if inv.Rows {
+			fmt.Println("  only when both lock the same rows")
+		}
+		if i < len(results) {
+			fmt.Printf("  confirmed by running the schedule: %s\n", results[i].Outcome)
+		}
+	}
+}
+
+// withoutComments drops the comment lines kept before a statement.
+func withoutComments(statement string) string {
+	lines := strings.Split(statement, "\n")
+	for len(lines) > 1 && strings.HasPrefix(strings.TrimSpace(lines[0]), "--") {
+		lines = lines[1:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,219 @@
+// Package deadlockreport prints the results of scenario runs and the
+// deadlocks, wait-for graphs and stalls captured while running them.
+package deadlockreport
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/13rac1/pg-deadlocks/lockmon"
+	"github.com/13rac1/pg-deadlocks/scenario"
+)
+
+// PrintResult prints the result of a run to stdout, see WriteResult.
+func PrintResult(r scenario.Result, allLocks bool) {
+	WriteResult(os.Stdout, r, allLocks)
+}
+
+// WriteResult writes the steps of a run and the locks, wait-for graphs and
+// deadlocks captured while running them. allLocks writes every lock granted
+// by a step instead of only the SIReadLock predicate locks.
+func WriteResult(out io.Writer, r scenario.Result, allLocks bool) {
+	fmt.Fprintf(out, "scenario %s: %s (%s)\n", r.Scenario, r.Outcome, r.Duration.Round(time.Millisecond))
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, s := range r.Steps {
+		status := s.Outcome().String()
+		if s.Err != nil && !s.Blocked {
+			status += ": " + s.Err.Error()
+		}
+		fmt.Fprintf(w, "  s%d\t%s\t%s\t%s\n", s.Session, oneLine(s.SQL), s.Duration.Round(time.Millisecond), status)
+	}
+	w.Flush()
+
+	for _, s := range r.Steps {
+		// SIReadLock predicate locks are always shown, they explain
+		// serialization failures.
+		var locks []string
+		for _, l := range s.Locks {
+			if allLocks || l.Mode == "SIReadLock" {
+				locks = append(locks, l.String())
+			}
+		}
+		if len(locks) > 0 {
+			fmt.Fprintf(out, "  s%d locks granted by %s: %s\n", s.Session, oneLine(s.SQL), strings.Join(locks, ", "))
+		}
+	}
+	for i, s := range r.Steps {
+		if s.Graph != nil && len(s.Graph.Edges) > 0 {
+			fmt.Fprintf(out, "  waiting at step %d:\n", i+1)
+			printSessionXacts(out, r, *s.Graph)
+			WriteLockGraph(out, *s.Graph, r.SessionName)
+		}
+	}
+	for _, s := range r.Steps {
+		if d, ok := lockmon.ParseDeadlock(s.Err); ok {
+			printDeadlock(out, r, s, d)
+		}
+	}
+	if r.Graph != nil {
+		fmt.Fprintln(out, "  deadlock Postgres cannot detect:")
+		WriteLockGraph(out, *r.Graph, func(session int) string { return fmt.Sprintf("s%d", session) })
+	}
+	if r.PoolStall != nil {
+		WritePoolStall(out, *r.PoolStall)
+	}
+}
+
+// printSessionXacts prints the virtual, top level and subtransaction ids of
+// the sessions.
+func printSessionXacts(out io.Writer, r scenario.Result, g lockmon.LockGraph) {
+	for _, x := range g.Xacts {
+		name := r.SessionName(x.PID)
+		if strings.HasPrefix(name, "pid") || x.XID == "" {
+			continue
+		}
+		fmt.Fprintf(out, "    %s pid:%d vxid:%s xid:%s", name, x.PID, x.VirtualXID, x.XID)
+		if len(x.SubXIDs) > 0 {
+			fmt.Fprintf(out, " subxids:%s", strings.Join(x.SubXIDs, ","))
+		}
+		fmt.Fprintln(out)
+	}
+}
+
+// printDeadlock prints the wait cycle of a deadlock error in terms of
+// sessions, and for each wait the statement which first took the lock being
+// waited on. With triggers and cascading foreign keys that statement does not
+// mention the table it locked. Waits on rows are attributed to the first
+// write of the holder, and are labelled as such.
+func printDeadlock(out io.Writer, r scenario.Result, victim scenario.StepResult, d lockmon.DeadlockDetail) {
+	sessionOf := map[int]int{}
+	for id, pid := range r.PIDs {
+		sessionOf[pid] = id
+	}
+	names := map[int]string{}
+	for _, s := range r.Steps {
+		for _, l := range s.Locks {
+			if l.RelationName != "" {
+				names[l.Relation] = l.RelationName
+			}
+		}
+	}
+	fmt.Fprintf(out, "  deadlock detected by s%d\n", victim.Session)
+	for _, wait := range d.Waits {
+		fmt.Fprintf(out, "    %s waits for %s on %s held by %s\n", r.SessionName(wait.PID), wait.Mode,
+			lockmon.DescribeTarget(wait.Target, names), r.SessionName(wait.BlockedBy))
+		// Only the locks of the sessions of the scenario are known.
+		holder, ok := sessionOf[wait.BlockedBy]
+		if !ok {
+			continue
+		}
+		relation := ""
+		if wait.PID == r.PIDs[victim.Session] {
+			relation = d.Relation
+		}
+		st, l, ok := lockedBy(r, holder, wait, relation)
+		switch {
+		case !ok:
+		case wait.LockType != "transactionid":
+			fmt.Fprintf(out, "      %s taken by s%d: %s\n", l, holder, oneLine(st.SQL))
+		case relation != "":
+			// The row lock itself is not in pg_locks, only the first
+			// statement of the holder writing to the relation is known.
+			fmt.Fprintf(out, "      tuple %s of %s probably locked by s%d: %s (first write of s%d to %s, a later statement may have locked the row)\n",
+				d.Tuple, relation, holder, oneLine(st.SQL), holder, relation)
+		default:
+			fmt.Fprintf(out, "      %s taken by s%d: %s (first write of s%d, a later statement may have locked the row)\n",
+				l, holder, oneLine(st.SQL), holder)
+		}
+	}
+	if d.Where != "" {
+		fmt.Fprintf(out, "    context: %s\n", oneLine(d.Where))
+	}
+}
+
+// lockedBy returns the first step of the session which was granted the lock
+// the wait is blocked on.
+func lockedBy(r scenario.Result, session int, wait lockmon.DeadlockWait, relation string) (scenario.StepResult, lockmon.HeldLock, bool) {
+	for _, s := range r.Steps {
+		if s.Session != session {
+			continue
+		}
+		for _, l := range s.Locks {
+			if wait.Holds(l, relation) {
+				return s, l, true
+			}
+		}
+	}
+	return scenario.StepResult{}, lockmon.HeldLock{}, false
+}
+
+// PrintSummary prints one line per run, to compare the outcome of a family
+// of scenarios.
+func PrintSummary(results []scenario.Result) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.Scenario, r.Outcome, r.Duration.Round(time.Millisecond))
+	}
+	w.Flush()
+}
+
+// PrintLockGraph prints the edges and cycle of g to stdout, naming backends
+// with name.
+func PrintLockGraph(g lockmon.LockGraph, name func(pid int) string) {
+	WriteLockGraph(os.Stdout, g, name)
+}
+
+// WriteLockGraph writes the edges and cycle of g, naming backends with name.
+func WriteLockGraph(out io.Writer, g lockmon.LockGraph, name func(pid int) string) {
+	for _, e := range g.Edges {
+		holder := name(e.Holder)
+		if xid := strings.TrimPrefix(e.Target, "transaction "); xid != e.Target {
+			if x, ok := g.Owner(xid); ok && x.XID != xid {
+				holder += fmt.Sprintf(" (subtransaction of xid %s)", x.XID)
+			}
+		}
+		fmt.Fprintf(out, "    %s waits for %s on %s held by %s\n", name(e.Waiter), e.Mode, e.Target, holder)
+	}
+	if cycle := g.FindCycle(); cycle != nil {
+		names := make([]string, len(cycle))
+		for i, pid := range cycle {
+			names[i] = name(pid)
+		}
+		fmt.Fprintf(out, "    cycle: %s\n", strings.Join(names, " -> "))
+	}
+}
+
+// PrintServerDeadlock prints a deadlock parsed from the server log.
+func PrintServerDeadlock(d lockmon.ServerDeadlock) {
+	fmt.Println("server log: deadlock detected")
+	for _, w := range d.Waits {
+		fmt.Printf("  pid %d waits for %s on %s (%s) blocked by pid %d\n",
+			w.PID, w.Mode, w.Target, w.LockType, w.BlockedBy)
+	}
+	for _, w := range d.Waits {
+		if q, ok := d.Queries[w.PID]; ok {
+			fmt.Printf("  pid %d: %s\n", w.PID, oneLine(q))
+		}
+	}
+}
+
+// PrintPoolStall prints a stalled connection pool to stdout.
+func PrintPoolStall(s lockmon.PoolStall) {
+	WritePoolStall(os.Stdout, s)
+}
+
+// WritePoolStall writes the pool stats and goroutine stacks of a stalled
+// connection pool.
+func WritePoolStall(out io.Writer, s lockmon.PoolStall) {
+	fmt.Fprintln(out, "application-level deadlock: connection pool exhausted without progress")
+	fmt.Fprintf(out, "%#v\n", s.Stats)
+	fmt.Fprintln(out, s.Stacks)
+}
+
+func oneLine(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
@@ -0,0 +1,104 @@
+package deadlockreport
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+
+	"github.com/13rac1/pg-deadlocks/lockmon"
+	"github.com/13rac1/pg-deadlocks/scenario"
+)
+
+func TestPrintDeadlock(t *testing.T) {
+	accounts := func(mode string) lockmon.HeldLock {
+		return lockmon.HeldLock{LockType: "relation", Mode: mode, Relation: 16386, RelationName: "accounts", Target: "relation accounts"}
+	}
+	xact := func(xid string) lockmon.HeldLock {
+		return lockmon.HeldLock{LockType: "transactionid", Mode: "ExclusiveLock", TransactionID: xid, Target: "transaction " + xid}
+	}
+	steps := func(locks1, locks2 []lockmon.HeldLock, err error) []scenario.StepResult {
+		return []scenario.StepResult{
+			{Step: scenario.Step{Session: 1, SQL: "SELECT * FROM accounts"}, Locks: []lockmon.HeldLock{accounts("AccessShareLock")}},
+			{Step: scenario.Step{Session: 1, SQL: "UPDATE accounts SET balance = 1 WHERE id = 1"}, Locks: locks1},
+			{Step: scenario.Step{Session: 2, SQL: "UPDATE accounts SET balance = 2 WHERE id = 2"}, Locks: locks2},
+			{Step: scenario.Step{Session: 1, SQL: "UPDATE accounts SET balance = 1 WHERE id = 2"}, Err: err},
+			{Step: scenario.Step{Session: 2, SQL: "UPDATE accounts SET balance = 2 WHERE id = 1"}},
+		}
+	}
+	deadlock := func(detail, where string) error {
+		return &pq.Error{Code: "40P01", Message: "deadlock detected", Detail: detail, Where: where}
+	}
+	rows := deadlock("Process 101 waits for ShareLock on transaction 501; blocked by process 102.\n"+
+		"Process 102 waits for ShareLock on transaction 500; blocked by process 101.",
+		`while updating tuple (0,2) in relation "accounts"`)
+
+	tests := []struct {
+		name  string
+		steps []scenario.StepResult
+		want  string
+	}{
+		{
+			name: "rows",
+			steps: steps(
+				[]lockmon.HeldLock{accounts("RowExclusiveLock"), xact("500")},
+				[]lockmon.HeldLock{accounts("RowExclusiveLock"), xact("501")}, rows),
+			want: `  deadlock detected by s1
+    s1 waits for ShareLock on transaction 501 held by s2
+      tuple (0,2) of accounts probably locked by s2: UPDATE accounts SET balance = 2 WHERE id = 2 (first write of s2 to accounts, a later statement may have locked the row)
+    s2 waits for ShareLock on transaction 500 held by s1
+      ExclusiveLock on transaction 500 taken by s1: UPDATE accounts SET balance = 1 WHERE id = 1 (first write of s1, a later statement may have locked the row)
+    context: while updating tuple (0,2) in relation "accounts"
+`,
+		},
+		{
+			name: "no lock row matches",
+			steps: steps(
+				[]lockmon.HeldLock{accounts("RowExclusiveLock")},
+				[]lockmon.HeldLock{xact("501")}, rows),
+			want: `  deadlock detected by s1
+    s1 waits for ShareLock on transaction 501 held by s2
+    s2 waits for ShareLock on transaction 500 held by s1
+    context: while updating tuple (0,2) in relation "accounts"
+`,
+		},
+		{
+			name: "relations",
+			steps: steps(
+				[]lockmon.HeldLock{accounts("RowExclusiveLock")},
+				[]lockmon.HeldLock{accounts("RowExclusiveLock")},
+				deadlock("Process 101 waits for AccessExclusiveLock on relation 16386 of database 1; blocked by process 102.\n"+
+					"Process 102 waits for AccessExclusiveLock on relation 16386 of database 1; blocked by process 101.", "")),
+			want: `  deadlock detected by s1
+    s1 waits for AccessExclusiveLock on relation accounts held by s2
+      RowExclusiveLock on relation accounts taken by s2: UPDATE accounts SET balance = 2 WHERE id = 2
+    s2 waits for AccessExclusiveLock on relation accounts held by s1
+      AccessShareLock on relation accounts taken by s1: SELECT * FROM accounts
+`,
+		},
+		{
+			name: "holder outside the scenario",
+			steps: steps(nil, nil, deadlock("Process 101 waits for ShareLock on transaction 9; blocked by process 999.\n"+
+				"Process 999 waits for ShareLock on transaction 500; blocked by process 101.", "")),
+			want: `  deadlock detected by s1
+    s1 waits for ShareLock on transaction 9 held by pid 999
+    pid 999 waits for ShareLock on transaction 500 held by s1
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := scenario.Result{Steps: tt.steps, PIDs: map[int]int{1: 101, 2: 102}}
+			victim := r.Steps[3]
+			d, ok := lockmon.ParseDeadlock(victim.Err)
+			if !ok {
+				t.Fatalf("%v is not a deadlock", victim.Err)
+			}
+			var b strings.Builder
+			printDeadlock(&b, r, victim, d)
+			if got := b.String(); got != tt.want {
+				t.Errorf("printed:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
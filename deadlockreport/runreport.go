@@ -0,0 +1,349 @@
+package deadlockreport
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/13rac1/pg-deadlocks/lockmon"
+	"github.com/13rac1/pg-deadlocks/scenario"
+)
+
+// RunReport is the structured report of a scenario run.
+type RunReport struct {
+	Scenario      string         `json:"scenario"`
+	Outcome       string         `json:"outcome"`
+	ServerVersion int            `json:"server_version,omitempty"`
+	Seconds       float64        `json:"seconds"`
+	Steps         []StepReport   `json:"steps"`
+	WaitGraphs    []GraphReport  `json:"wait_graphs,omitempty"`
+	Deadlocks     []DeadlockInfo `json:"deadlocks,omitempty"`
+	// Output is the text of WriteResult.
+	Output string `json:"-"`
+}
+
+// StepReport is the result of a step.
+type StepReport struct {
+	Session   int     `json:"session"`
+	SQL       string  `json:"sql"`
+	Outcome   string  `json:"outcome"`
+	Seconds   float64 `json:"seconds"`
+	ErrorCode string  `json:"error_code,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// GraphReport is a wait-for graph captured while a step waited, or for
+// deadlocks Postgres cannot detect after the run, Step is then 0. Sessions
+// are named s0, s1 and so on.
+type GraphReport struct {
+	Step  int         `json:"step,omitempty"`
+	Edges []EdgeInfo  `json:"edges"`
+	Cycle []string    `json:"cycle,omitempty"`
+	Xacts []XactsInfo `json:"xacts,omitempty"`
+}
+
+// EdgeInfo is an edge of a wait-for graph.
+type EdgeInfo struct {
+	Waiter string `json:"waiter"`
+	Holder string `json:"holder"`
+	Mode   string `json:"mode"`
+	Target string `json:"target"`
+}
+
+// XactsInfo are the transaction ids of a session.
+type XactsInfo struct {
+	Session    string   `json:"session"`
+	VirtualXID string   `json:"vxid,omitempty"`
+	XID        string   `json:"xid,omitempty"`
+	SubXIDs    []string `json:"subxids,omitempty"`
+}
+
+// DeadlockInfo is a deadlock error parsed from a step.
+type DeadlockInfo struct {
+	Step     int        `json:"step"`
+	Victim   string     `json:"victim"`
+	Waits    []WaitInfo `json:"waits"`
+	Relation string     `json:"relation,omitempty"`
+	Where    string     `json:"where,omitempty"`
+	// Message is the error as the server log shows it.
+	Message string `json:"message"`
+}
+
+// WaitInfo is a wait of the cycle of a deadlock error.
+type WaitInfo struct {
+	Session   string `json:"session"`
+	Mode      string `json:"mode"`
+	LockType  string `json:"locktype"`
+	Target    string `json:"target"`
+	BlockedBy string `json:"blocked_by"`
+}
+
+// NewRunReport returns the report of a run.
+func NewRunReport(r scenario.Result) RunReport {
+	var out bytes.Buffer
+	WriteResult(&out, r, false)
+	report := RunReport{
+		Scenario:      r.Scenario,
+		Outcome:       r.Outcome.String(),
+		ServerVersion: r.ServerVersion,
+		Seconds:       r.Duration.Seconds(),
+		Output:        out.String(),
+	}
+	for i, s := range r.Steps {
+		step := StepReport{
+			Session:   s.Session,
+			SQL:       s.SQL,
+			Outcome:   s.Outcome().String(),
+			Seconds:   s.Duration.Seconds(),
+			ErrorCode: string(scenario.ErrorCode(s.Err)),
+		}
+		if s.Err != nil {
+			step.Error = s.Err.Error()
+		}
+		report.Steps = append(report.Steps, step)
+		if s.Graph != nil && len(s.Graph.Edges) > 0 {
+			report.WaitGraphs = append(report.WaitGraphs, graphReport(i+1, *s.Graph, r.SessionName))
+		}
+		if d, ok := lockmon.ParseDeadlock(s.Err); ok {
+			report.Deadlocks = append(report.Deadlocks, deadlockInfo(r, i+1, s, d))
+		}
+	}
+	if r.Graph != nil {
+		report.WaitGraphs = append(report.WaitGraphs, graphReport(0, *r.Graph, func(session int) string {
+			return fmt.Sprintf("s%d", session)
+		}))
+	}
+	return report
+}
+
+func graphReport(step int, g lockmon.LockGraph, name func(pid int) string) GraphReport {
+	report := GraphReport{Step: step}
+	for _, e := range g.Edges {
+		report.Edges = append(report.Edges, EdgeInfo{name(e.Waiter), name(e.Holder), e.Mode, e.Target})
+	}
+	for _, pid := range g.FindCycle() {
+		report.Cycle = append(report.Cycle, name(pid))
+	}
+	for _, x := range g.Xacts {
+		if x.XID != "" {
+			report.Xacts = append(report.Xacts, XactsInfo{name(x.PID), x.VirtualXID, x.XID, x.SubXIDs})
+		}
+	}
+	return report
+}
+
+func deadlockInfo(r scenario.Result, step int, s scenario.StepResult, d lockmon.DeadlockDetail) DeadlockInfo {
+	info := DeadlockInfo{
+		Step:     step,
+		Victim:   fmt.Sprintf("s%d", s.Session),
+		Relation: d.Relation,
+		Where:    d.Where,
+		Message:  serverMessage(s.Err),
+	}
+	for _, w := range d.Waits {
+		info.Waits = append(info.Waits, WaitInfo{r.SessionName(w.PID), w.Mode, w.LockType, w.Target, r.SessionName(w.BlockedBy)})
+	}
+	return info
+}
+
+// serverMessage formats an error like the server log does.
+func serverMessage(err error) string {
+	var e *pq.Error
+	if !errors.As(err, &e) {
+		return err.Error()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s:  %s\n", e.Severity, e.Message)
+	for _, field := range []struct{ name, value string }{
+		{"DETAIL", e.Detail}, {"HINT", e.Hint}, {"CONTEXT", e.Where},
+	} {
+		if field.value != "" {
+			fmt.Fprintf(&b, "%s:  %s\n", field.name, strings.ReplaceAll(field.value, "\n", "\n        "))
+		}
+	}
+	return b.String()
+}
+
+// WriteReports writes the reports to each file in the format of its
+// extension: .json, .xml for JUnit XML or .md for Markdown.
+func WriteReports(names []string, reports []RunReport) error {
+	for _, name := range names {
+		var write func(io.Writer, []RunReport) error
+		switch filepath.Ext(name) {
+		case ".json":
+			write = WriteJSONReport
+		case ".xml":
+			write = WriteJUnitReport
+		case ".md":
+			write = WriteMarkdownReport
+		default:
+			return fmt.Errorf("unknown report format of %s, use .json, .xml or .md", name)
+		}
+		f, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		err = write(f, reports)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("unable to write %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// WriteJSONReport writes the reports as a JSON array.
+func WriteJSONReport(out io.Writer, reports []RunReport) error {
+	if reports == nil {
+		reports = []RunReport{}
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(reports)
+}
+
+type junitSuite struct {
+	XMLName  xml.Name    `xml:"testsuite"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnitReport writes the reports as a JUnit XML test suite with a test
+// case per scenario. Deadlocks and blocked sessions are failures, errors of
+// steps are errors, skipped scenarios are skipped.
+func WriteJUnitReport(out io.Writer, reports []RunReport) error {
+	suite := junitSuite{Name: "pg-deadlocks", Tests: len(reports)}
+	var total float64
+	for _, r := range reports {
+		total += r.Seconds
+		c := junitCase{
+			Name:      r.Scenario,
+			ClassName: "pg-deadlocks." + strings.SplitN(r.Scenario, "/", 2)[0],
+			Time:      seconds(r.Seconds),
+			SystemOut: r.Output,
+		}
+		// The failure shows the deadlock errors, the full output is in
+		// system-out.
+		message := &junitMessage{Message: r.Outcome, Text: r.Output}
+		if len(r.Deadlocks) > 0 {
+			message.Text = ""
+			for _, d := range r.Deadlocks {
+				message.Text += d.Message
+			}
+		}
+		switch r.Outcome {
+		case scenario.OutcomeDeadlock.String(), scenario.OutcomeAppDeadlock.String(), scenario.OutcomeBlocked.String():
+			c.Failure = message
+			suite.Failures++
+		case scenario.OutcomeError.String():
+			c.Error = message
+			suite.Errors++
+		case scenario.OutcomeSkipped.String():
+			c.Skipped = &junitMessage{Message: "server version not supported"}
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	suite.Time = seconds(total)
+	_, err := io.WriteString(out, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(out)
+	enc.Indent("", "  ")
+	err = enc.Encode(suite)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(out, "\n")
+	return err
+}
+
+func seconds(s float64) string {
+	return fmt.Sprintf("%.3f", s)
+}
+
+// WriteMarkdownReport writes the reports as Markdown to paste into incident
+// documents: a section per scenario with a table of the steps and the wait
+// graphs and deadlock errors in code blocks.
+func WriteMarkdownReport(out io.Writer, reports []RunReport) error {
+	var b strings.Builder
+	for _, r := range reports {
+		fmt.Fprintf(&b, "## %s: %s\n\n", r.Scenario, r.Outcome)
+		if r.ServerVersion > 0 {
+			fmt.Fprintf(&b, "Server version %d, ", r.ServerVersion)
+		}
+		fmt.Fprintf(&b, "%s.\n\n", time.Duration(r.Seconds*float64(time.Second)).Round(time.Millisecond))
+		if len(r.Steps) > 0 {
+			b.WriteString("| Step | Session | Statement | Duration | Outcome | Error code |\n")
+			b.WriteString("| ---: | --- | --- | ---: | --- | --- |\n")
+			for i, s := range r.Steps {
+				fmt.Fprintf(&b, "| %d | s%d | `%s` | %s | %s | %s |\n", i+1, s.Session,
+					markdownCell(oneLine(s.SQL)), time.Duration(s.Seconds*float64(time.Second)).Round(time.Millisecond),
+					s.Outcome, s.ErrorCode)
+			}
+			b.WriteString("\n")
+		}
+		for _, g := range r.WaitGraphs {
+			if g.Step > 0 {
+				fmt.Fprintf(&b, "### Waiting at step %d\n\n", g.Step)
+			} else {
+				b.WriteString("### Deadlock Postgres cannot detect\n\n")
+			}
+			b.WriteString("```bash\n")
+			for _, e := range g.Edges {
+				fmt.Fprintf(&b, "%s waits for %s on %s held by %s\n", e.Waiter, e.Mode, e.Target, e.Holder)
+			}
+			if len(g.Cycle) > 0 {
+				fmt.Fprintf(&b, "cycle: %s\n", strings.Join(g.Cycle, " -> "))
+			}
+			b.WriteString("```\n\n")
+		}
+		for _, d := range r.Deadlocks {
+			fmt.Fprintf(&b, "### Deadlock detected by %s at step %d\n\n```bash\n", d.Victim, d.Step)
+			for _, w := range d.Waits {
+				fmt.Fprintf(&b, "%s waits for %s on %s; blocked by %s\n", w.Session, w.Mode, w.Target, w.BlockedBy)
+			}
+			fmt.Fprintf(&b, "%s```\n\n", d.Message)
+		}
+	}
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+// markdownCell escapes the characters of s which end a table cell or an
+// inline code span.
+func markdownCell(s string) string {
+	return strings.NewReplacer("|", `\|`, "`", "'").Replace(s)
+}
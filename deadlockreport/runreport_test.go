@@ -0,0 +1,483 @@
+package deadlockreport
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/13rac1/pg-deadlocks/lockmon"
+	"github.com/13rac1/pg-deadlocks/scenario"
+)
+
+var deadlockErr = &pq.Error{
+	Severity: "ERROR",
+	Code:     "40P01",
+	Message:  "deadlock detected",
+	Detail: "Process 102 waits for ShareLock on transaction 500; blocked by process 101.\n" +
+		"Process 101 waits for ShareLock on transaction 501; blocked by process 102.",
+	Hint:  "See server log for query details.",
+	Where: `while updating tuple (0,1) in relation "a"`,
+}
+
+const deadlockMessage = `ERROR:  deadlock detected
+DETAIL:  Process 102 waits for ShareLock on transaction 500; blocked by process 101.
+        Process 101 waits for ShareLock on transaction 501; blocked by process 102.
+HINT:  See server log for query details.
+CONTEXT:  while updating tuple (0,1) in relation "a"
+`
+
+// testReports are a report of every outcome a JUnit test case maps.
+var testReports = []RunReport{
+	{
+		Scenario:      "lock-order/rows",
+		Outcome:       "deadlock",
+		ServerVersion: 140005,
+		Seconds:       1.25,
+		Steps: []StepReport{
+			{Session: 1, SQL: "UPDATE a\n  SET v = '|'", Outcome: "ok", Seconds: 0.001},
+			{Session: 2, SQL: "SELECT `x`", Outcome: "deadlock", Seconds: 1, ErrorCode: "40P01", Error: "pq: deadlock detected"},
+		},
+		WaitGraphs: []GraphReport{{
+			Step:  2,
+			Edges: []EdgeInfo{{Waiter: "s2", Holder: "s1", Mode: "ShareLock", Target: "transaction 500"}},
+			Cycle: []string{"s2", "s1", "s2"},
+		}},
+		Deadlocks: []DeadlockInfo{{
+			Step:    2,
+			Victim:  "s2",
+			Waits:   []WaitInfo{{Session: "s2", Mode: "ShareLock", LockType: "transactionid", Target: "transaction 500", BlockedBy: "s1"}},
+			Message: "ERROR:  deadlock detected\n",
+		}},
+		Output: "deadlock <s2>\n",
+	},
+	{Scenario: "bad/syntax", Outcome: "error", Seconds: 0.5, Output: "syntax error\n"},
+	{Scenario: "new/feature", Outcome: "skipped"},
+	{Scenario: "pool/stall", Outcome: "blocked", Seconds: 2, Output: "s2 blocked\n"},
+	{
+		Scenario: "app/mutex",
+		Outcome:  "application-deadlock",
+		WaitGraphs: []GraphReport{{
+			Edges: []EdgeInfo{
+				{Waiter: "s1", Holder: "s2", Mode: "Mutex", Target: "m2"},
+				{Waiter: "s2", Holder: "s1", Mode: "Mutex", Target: "m1"},
+			},
+			Cycle: []string{"s1", "s2", "s1"},
+		}},
+		Output: "application deadlock\n",
+	},
+	{Scenario: "lock-order/rows+ordered", Outcome: "ok", Seconds: 0.25},
+}
+
+func TestNewRunReport(t *testing.T) {
+	r := scenario.Result{
+		Scenario:      "lock-order/rows",
+		Outcome:       scenario.OutcomeDeadlock,
+		Duration:      1250 * time.Millisecond,
+		PIDs:          map[int]int{1: 101, 2: 102},
+		ServerVersion: 140005,
+		Steps: []scenario.StepResult{
+			{Step: scenario.Step{Session: 1, SQL: "UPDATE a SET v = 1 WHERE id = 1"}, Duration: time.Millisecond},
+			{
+				Step:     scenario.Step{Session: 2, SQL: "UPDATE a SET v = 2 WHERE id = 1"},
+				Err:      deadlockErr,
+				Duration: time.Second,
+				Graph: &lockmon.LockGraph{
+					Xacts: []lockmon.Xacts{{PID: 101, VirtualXID: "3/7", XID: "500"}, {PID: 102, VirtualXID: "4/2"}},
+					Edges: []lockmon.WaitEdge{{Waiter: 102, Holder: 101, Mode: "ShareLock", Target: "transaction 500"}},
+				},
+			},
+		},
+		Graph: &lockmon.LockGraph{Edges: []lockmon.WaitEdge{
+			{Waiter: 1, Holder: 2, Mode: "Mutex", Target: "m2"},
+			{Waiter: 2, Holder: 1, Mode: "Mutex", Target: "m1"},
+		}},
+	}
+	var out bytes.Buffer
+	WriteResult(&out, r, false)
+	want := RunReport{
+		Scenario:      "lock-order/rows",
+		Outcome:       "deadlock",
+		ServerVersion: 140005,
+		Seconds:       1.25,
+		Steps: []StepReport{
+			{Session: 1, SQL: "UPDATE a SET v = 1 WHERE id = 1", Outcome: "ok", Seconds: 0.001},
+			{Session: 2, SQL: "UPDATE a SET v = 2 WHERE id = 1", Outcome: "deadlock", Seconds: 1, ErrorCode: "40P01", Error: "pq: deadlock detected"},
+		},
+		WaitGraphs: []GraphReport{
+			{
+				Step:  2,
+				Edges: []EdgeInfo{{Waiter: "s2", Holder: "s1", Mode: "ShareLock", Target: "transaction 500"}},
+				Xacts: []XactsInfo{{Session: "s1", VirtualXID: "3/7", XID: "500"}},
+			},
+			{
+				Edges: []EdgeInfo{
+					{Waiter: "s1", Holder: "s2", Mode: "Mutex", Target: "m2"},
+					{Waiter: "s2", Holder: "s1", Mode: "Mutex", Target: "m1"},
+				},
+				Cycle: []string{"s1", "s2", "s1"},
+			},
+		},
+		Deadlocks: []DeadlockInfo{{
+			Step:   2,
+			Victim: "s2",
+			Waits: []WaitInfo{
+				{Session: "s2", Mode: "ShareLock", LockType: "transactionid", Target: "transaction 500", BlockedBy: "s1"},
+				{Session: "s1", Mode: "ShareLock", LockType: "transactionid", Target: "transaction 501", BlockedBy: "s2"},
+			},
+			Relation: "a",
+			Where:    `while updating tuple (0,1) in relation "a"`,
+			Message:  deadlockMessage,
+		}},
+		Output: out.String(),
+	}
+	got := NewRunReport(r)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NewRunReport() = %+v, want %+v", got, want)
+	}
+}
+
+func TestServerMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "message only",
+			err:  &pq.Error{Severity: "ERROR", Code: "40001", Message: "could not serialize access due to concurrent update"},
+			want: "ERROR:  could not serialize access due to concurrent update\n",
+		},
+		{
+			name: "detail, hint and context",
+			err:  deadlockErr,
+			want: deadlockMessage,
+		},
+		{
+			name: "multiline context",
+			err: &pq.Error{Severity: "ERROR", Code: "40P01", Message: "deadlock detected",
+				Where: "while locking tuple (0,1) in relation \"a\"\nSQL statement \"SELECT 1 FROM ONLY \"public\".\"a\" x FOR KEY SHARE OF x\""},
+			want: `ERROR:  deadlock detected
+CONTEXT:  while locking tuple (0,1) in relation "a"
+        SQL statement "SELECT 1 FROM ONLY "public"."a" x FOR KEY SHARE OF x"
+`,
+		},
+		{
+			name: "wrapped",
+			err:  errors.New("step 2: pq: deadlock detected"),
+			want: "step 2: pq: deadlock detected",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := serverMessage(tt.err)
+			if got != tt.want {
+				t.Errorf("serverMessage():\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarkdownCell(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "SELECT 1", want: "SELECT 1"},
+		{in: "SELECT 'a|b'", want: `SELECT 'a\|b'`},
+		{in: "SELECT `x`", want: "SELECT 'x'"},
+		{in: "SELECT a || b", want: `SELECT a \|\| b`},
+	}
+	for _, tt := range tests {
+		got := markdownCell(tt.in)
+		if got != tt.want {
+			t.Errorf("markdownCell(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWriteJSONReport(t *testing.T) {
+	tests := []struct {
+		name    string
+		reports []RunReport
+		want    string
+	}{
+		{
+			name: "none",
+			want: "[]\n",
+		},
+		{
+			name:    "error",
+			reports: []RunReport{testReports[1]},
+			want: `[
+  {
+    "scenario": "bad/syntax",
+    "outcome": "error",
+    "seconds": 0.5,
+    "steps": null
+  }
+]
+`,
+		},
+		{
+			name:    "deadlock",
+			reports: []RunReport{testReports[0]},
+			want: `[
+  {
+    "scenario": "lock-order/rows",
+    "outcome": "deadlock",
+    "server_version": 140005,
+    "seconds": 1.25,
+    "steps": [
+      {
+        "session": 1,
+        "sql": "UPDATE a\n  SET v = '|'",
+        "outcome": "ok",
+        "seconds": 0.001
+      },
+      {
+        "session": 2,
+        "sql": "SELECT ` + "`x`" + `",
+        "outcome": "deadlock",
+        "seconds": 1,
+        "error_code": "40P01",
+        "error": "pq: deadlock detected"
+      }
+    ],
+    "wait_graphs": [
+      {
+        "step": 2,
+        "edges": [
+          {
+            "waiter": "s2",
+            "holder": "s1",
+            "mode": "ShareLock",
+            "target": "transaction 500"
+          }
+        ],
+        "cycle": [
+          "s2",
+          "s1",
+          "s2"
+        ]
+      }
+    ],
+    "deadlocks": [
+      {
+        "step": 2,
+        "victim": "s2",
+        "waits": [
+          {
+            "session": "s2",
+            "mode": "ShareLock",
+            "locktype": "transactionid",
+            "target": "transaction 500",
+            "blocked_by": "s1"
+          }
+        ],
+        "message": "ERROR:  deadlock detected\n"
+      }
+    ]
+  }
+]
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			err := WriteJSONReport(&out, tt.reports)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("WriteJSONReport():\n%s\nwant:\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteJUnitReport(t *testing.T) {
+	tests := []struct {
+		name    string
+		reports []RunReport
+		want    string
+	}{
+		{
+			name: "none",
+			want: `<?xml version="1.0" encoding="UTF-8"?>
+<testsuite name="pg-deadlocks" tests="0" failures="0" errors="0" skipped="0" time="0.000"></testsuite>
+`,
+		},
+		{
+			name:    "outcomes",
+			reports: testReports,
+			want: `<?xml version="1.0" encoding="UTF-8"?>
+<testsuite name="pg-deadlocks" tests="6" failures="3" errors="1" skipped="1" time="4.000">
+  <testcase name="lock-order/rows" classname="pg-deadlocks.lock-order" time="1.250">
+    <failure message="deadlock">ERROR:  deadlock detected&#xA;</failure>
+    <system-out>deadlock &lt;s2&gt;&#xA;</system-out>
+  </testcase>
+  <testcase name="bad/syntax" classname="pg-deadlocks.bad" time="0.500">
+    <error message="error">syntax error&#xA;</error>
+    <system-out>syntax error&#xA;</system-out>
+  </testcase>
+  <testcase name="new/feature" classname="pg-deadlocks.new" time="0.000">
+    <skipped message="server version not supported"></skipped>
+  </testcase>
+  <testcase name="pool/stall" classname="pg-deadlocks.pool" time="2.000">
+    <failure message="blocked">s2 blocked&#xA;</failure>
+    <system-out>s2 blocked&#xA;</system-out>
+  </testcase>
+  <testcase name="app/mutex" classname="pg-deadlocks.app" time="0.000">
+    <failure message="application-deadlock">application deadlock&#xA;</failure>
+    <system-out>application deadlock&#xA;</system-out>
+  </testcase>
+  <testcase name="lock-order/rows+ordered" classname="pg-deadlocks.lock-order" time="0.250"></testcase>
+</testsuite>
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			err := WriteJUnitReport(&out, tt.reports)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("WriteJUnitReport():\n%s\nwant:\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteMarkdownReport(t *testing.T) {
+	tests := []struct {
+		name    string
+		reports []RunReport
+		want    string
+	}{
+		{
+			name:    "deadlock",
+			reports: []RunReport{testReports[0]},
+			want: "## lock-order/rows: deadlock\n\n" +
+				"Server version 140005, 1.25s.\n\n" +
+				"| Step | Session | Statement | Duration | Outcome | Error code |\n" +
+				"| ---: | --- | --- | ---: | --- | --- |\n" +
+				"| 1 | s1 | `UPDATE a SET v = '\\|'` | 1ms | ok |  |\n" +
+				"| 2 | s2 | `SELECT 'x'` | 1s | deadlock | 40P01 |\n" +
+				"\n" +
+				"### Waiting at step 2\n\n" +
+				"```bash\n" +
+				"s2 waits for ShareLock on transaction 500 held by s1\n" +
+				"cycle: s2 -> s1 -> s2\n" +
+				"```\n\n" +
+				"### Deadlock detected by s2 at step 2\n\n" +
+				"```bash\n" +
+				"s2 waits for ShareLock on transaction 500; blocked by s1\n" +
+				"ERROR:  deadlock detected\n" +
+				"```\n\n",
+		},
+		{
+			name:    "application deadlock and error",
+			reports: []RunReport{testReports[4], testReports[1]},
+			want: "## app/mutex: application-deadlock\n\n" +
+				"0s.\n\n" +
+				"### Deadlock Postgres cannot detect\n\n" +
+				"```bash\n" +
+				"s1 waits for Mutex on m2 held by s2\n" +
+				"s2 waits for Mutex on m1 held by s1\n" +
+				"cycle: s1 -> s2 -> s1\n" +
+				"```\n\n" +
+				"## bad/syntax: error\n\n" +
+				"500ms.\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			err := WriteMarkdownReport(&out, tt.reports)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("WriteMarkdownReport():\n%s\nwant:\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteReports(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runreport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		file    string
+		write   func(w *bytes.Buffer) error
+		wantErr string
+	}{
+		{
+			name:  "json",
+			file:  "report.json",
+			write: func(w *bytes.Buffer) error { return WriteJSONReport(w, testReports) },
+		},
+		{
+			name:  "junit",
+			file:  "report.xml",
+			write: func(w *bytes.Buffer) error { return WriteJUnitReport(w, testReports) },
+		},
+		{
+			name:  "markdown",
+			file:  "report.md",
+			write: func(w *bytes.Buffer) error { return WriteMarkdownReport(w, testReports) },
+		},
+		{
+			name:    "unknown extension",
+			file:    "report.html",
+			wantErr: "unknown report format of %s, use .json, .xml or .md",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(dir, tt.file)
+			err := WriteReports([]string{name}, testReports)
+			if tt.wantErr != "" {
+				want := strings.Replace(tt.wantErr, "%s", name, 1)
+				if err == nil || err.Error() != want {
+					t.Fatalf("WriteReports() error = %v, want %s", err, want)
+				}
+				if _, err := os.Stat(name); !os.IsNotExist(err) {
+					t.Errorf("%s was created", name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want bytes.Buffer
+			err = tt.write(&want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != want.String() {
+				t.Errorf("%s:\n%s\nwant:\n%s", tt.file, got, want.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package deadlockreport
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/13rac1/pg-deadlocks/lockmon"
+)
+
+// WriteTop writes a screen of the top command: a table of the sessions and
+// a tree of the lock waits, each waiter indented below the session holding
+// the lock it waits for. Lines are cut to width.
+func WriteTop(out io.Writer, sessions []lockmon.Session, g lockmon.LockGraph, width int) {
+	waiting := 0
+	byPID := map[int]lockmon.Session{}
+	for _, s := range sessions {
+		byPID[s.PID] = s
+		if s.WaitEventType == "Lock" {
+			waiting++
+		}
+	}
+	fmt.Fprintf(out, "%d sessions, %d waiting for locks\n\n", len(sessions), waiting)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PID\tDATABASE\tUSER\tSTATE\tWAIT\tXACT\tRUNNING\tQUERY")
+	for _, s := range sessions {
+		wait := s.WaitEventType
+		if s.WaitEvent != "" {
+			wait += ":" + s.WaitEvent
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", s.PID, s.Database, s.UserName, s.State,
+			wait, age(s.XactAge()), age(s.QueryAge()), cut(oneLine(s.Query), width-80))
+	}
+	w.Flush()
+
+	if len(g.Edges) == 0 {
+		return
+	}
+	fmt.Fprintln(out, "\nlock waits:")
+	waiters := map[int][]lockmon.WaitEdge{}
+	isWaiter := map[int]bool{}
+	var holders []int
+	for _, e := range g.Edges {
+		if len(waiters[e.Holder]) == 0 {
+			holders = append(holders, e.Holder)
+		}
+		waiters[e.Holder] = append(waiters[e.Holder], e)
+		isWaiter[e.Waiter] = true
+	}
+	describe := func(pid int) string {
+		s, ok := byPID[pid]
+		if !ok {
+			return "not a client backend"
+		}
+		return fmt.Sprintf("%s for %s: %s", s.State, age(s.XactAge()), oneLine(s.Query))
+	}
+	printed := map[int]bool{}
+	var tree func(holder int, depth int, path map[int]bool)
+	tree = func(holder int, depth int, path map[int]bool) {
+		printed[holder] = true
+		path[holder] = true
+		defer delete(path, holder)
+		for _, e := range waiters[holder] {
+			indent := strings.Repeat("  ", depth)
+			line := fmt.Sprintf("%s└ %d waits for %s on %s, %s", indent, e.Waiter, e.Mode, e.Target, describe(e.Waiter))
+			fmt.Fprintln(out, cut(line, width))
+			if !path[e.Waiter] {
+				tree(e.Waiter, depth+1, path)
+			}
+		}
+	}
+	root := func(pid int) {
+		fmt.Fprintln(out, cut(fmt.Sprintf("%d %s", pid, describe(pid)), width))
+		tree(pid, 1, map[int]bool{})
+	}
+	for _, pid := range holders {
+		if !isWaiter[pid] {
+			root(pid)
+		}
+	}
+	// The sessions of a cycle all wait, trees of waits without a root start
+	// at the first holder of the cycle.
+	for _, pid := range holders {
+		if !printed[pid] {
+			root(pid)
+		}
+	}
+	if cycle := g.FindCycle(); cycle != nil {
+		pids := make([]string, len(cycle))
+		for i, pid := range cycle {
+			pids[i] = fmt.Sprintf("%d", pid)
+		}
+		fmt.Fprintf(out, "deadlock: %s, until the deadlock detector runs\n", strings.Join(pids, " -> "))
+	}
+}
+
+// age rounds a duration for display, an empty string for none.
+func age(d time.Duration) string {
+	switch {
+	case d <= 0:
+		return ""
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	default:
+		return d.Round(100 * time.Millisecond).String()
+	}
+}
+
+// cut shortens s to at most width characters.
+func cut(s string, width int) string {
+	if width < 20 {
+		width = 20
+	}
+	r := []rune(s)
+	if len(r) <= width {
+		return s
+	}
+	return string(r[:width-1]) + "…"
+}
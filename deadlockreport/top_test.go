@@ -0,0 +1,79 @@
+package deadlockreport
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/13rac1/pg-deadlocks/lockmon"
+)
+
+func TestWriteTopWaits(t *testing.T) {
+	sessions := []lockmon.Session{
+		{PID: 1, XactSeconds: 2, State: "idle in transaction", Query: "UPDATE a SET v = 1"},
+		{PID: 2, XactSeconds: 2, State: "active", WaitEventType: "Lock", Query: "UPDATE a SET v = 2"},
+		{PID: 3, XactSeconds: 2, State: "active", WaitEventType: "Lock", Query: "UPDATE b SET v = 3"},
+		{PID: 4, XactSeconds: 2, State: "active", WaitEventType: "Lock", Query: "UPDATE b SET v = 4"},
+		{PID: 5, XactSeconds: 2, State: "active", WaitEventType: "Lock", Query: "UPDATE a SET v = 5"},
+	}
+	tests := []struct {
+		name  string
+		edges []lockmon.WaitEdge
+		want  string
+	}{
+		{
+			name: "chain",
+			edges: []lockmon.WaitEdge{
+				{Waiter: 2, Holder: 1, Mode: "ShareLock", Target: "transaction 10"},
+				{Waiter: 5, Holder: 2, Mode: "ExclusiveLock", Target: "tuple (0,1) of a"},
+			},
+			want: `
+lock waits:
+1 idle in transaction for 2s: UPDATE a SET v = 1
+  └ 2 waits for ShareLock on transaction 10, active for 2s: UPDATE a SET v = 2
+    └ 5 waits for ExclusiveLock on tuple (0,1) of a, active for 2s: UPDATE a SET v = 5
+`,
+		},
+		{
+			name: "cycle",
+			edges: []lockmon.WaitEdge{
+				{Waiter: 3, Holder: 4, Mode: "ShareLock", Target: "transaction 11"},
+				{Waiter: 4, Holder: 3, Mode: "ShareLock", Target: "transaction 12"},
+				{Waiter: 5, Holder: 4, Mode: "ShareLock", Target: "transaction 11"},
+			},
+			want: `
+lock waits:
+4 active for 2s: UPDATE b SET v = 4
+  └ 3 waits for ShareLock on transaction 11, active for 2s: UPDATE b SET v = 3
+    └ 4 waits for ShareLock on transaction 12, active for 2s: UPDATE b SET v = 4
+  └ 5 waits for ShareLock on transaction 11, active for 2s: UPDATE a SET v = 5
+deadlock: `,
+		},
+		{
+			name: "chain and cycle",
+			edges: []lockmon.WaitEdge{
+				{Waiter: 2, Holder: 1, Mode: "ShareLock", Target: "transaction 10"},
+				{Waiter: 3, Holder: 4, Mode: "ShareLock", Target: "transaction 11"},
+				{Waiter: 4, Holder: 3, Mode: "ShareLock", Target: "transaction 12"},
+			},
+			want: `
+lock waits:
+1 idle in transaction for 2s: UPDATE a SET v = 1
+  └ 2 waits for ShareLock on transaction 10, active for 2s: UPDATE a SET v = 2
+4 active for 2s: UPDATE b SET v = 4
+  └ 3 waits for ShareLock on transaction 11, active for 2s: UPDATE b SET v = 3
+    └ 4 waits for ShareLock on transaction 12, active for 2s: UPDATE b SET v = 4
+deadlock: `,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			WriteTop(&b, sessions, lockmon.LockGraph{Edges: tt.edges}, 200)
+			got := b.String()
+			got = got[strings.Index(got, "\nlock waits:"):]
+			if !strings.HasPrefix(got, tt.want) {
+				t.Errorf("lock waits:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+// Package deadlocktest has go test helpers which assert that concurrent
+// statements deadlock, block or complete, so every deadlock fixed in
+// production can get a regression test against the application schema.
+//
+//	func TestTransferDeadlock(t *testing.T) {
+//		srv := deadlocktest.Start(t, pgcontainer.Config{})
+//		db := srv.Database(t, schema)
+//		deadlocktest.ExpectNoDeadlock(t, db, []scenario.Step{...})
+//	}
+package deadlocktest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/13rac1/pg-deadlocks/deadlockreport"
+	"github.com/13rac1/pg-deadlocks/pgcontainer"
+	"github.com/13rac1/pg-deadlocks/scenario"
+	"github.com/jmoiron/sqlx"
+)
+
+// Server is a Postgres container started for a test, or an existing server.
+type Server struct {
+	pgcontainer.Instance
+}
+
+// Start starts a Postgres container on a free port of 127.0.0.1 which is
+// removed once the test and its subtests complete. The test is skipped when
+// Docker is not available.
+func Start(t testing.TB, config pgcontainer.Config) *Server {
+	t.Helper()
+	ctx := context.Background()
+	docker, err := pgcontainer.NewClient()
+	if err != nil {
+		t.Skipf("docker is not available: %s", err)
+	}
+	// Creating the client does not contact the daemon.
+	_, err = docker.Ping(ctx)
+	if err != nil {
+		t.Skipf("docker is not available: %s", err)
+	}
+	pg, err := pgcontainer.Start(ctx, docker, config)
+	if err != nil {
+		t.Fatalf("unable to start Postgres: %s", err)
+	}
+	t.Cleanup(func() {
+		pg.DB.Close()
+		docker.RemoveContainer(ctx, pg.ID)
+	})
+	return &Server{pg}
+}
+
+// Connect returns a Server for an existing Postgres server, e.g. the one the
+// application tests already run against. dsn needs a role which can create
+// databases, the databases of the test are created and dropped on it.
+func Connect(t testing.TB, dsn string) *Server {
+	t.Helper()
+	db, err := sqlx.Connect("postgres", dsn)
+	if err != nil {
+		t.Fatalf("unable to connect to Postgres: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &Server{pgcontainer.Instance{Name: "postgres", DSN: dsn, DB: db}}
+}
+
+// Database creates a fresh database and runs setup in it. The database is
+// dropped once the test completes.
+func (s *Server) Database(t testing.TB, setup ...string) *sqlx.DB {
+	t.Helper()
+	db, closeDB, err := scenario.OpenDB(context.Background(), s.DB, s.DSN, setup)
+	if err != nil {
+		t.Fatalf("unable to create database: %s", err)
+	}
+	t.Cleanup(func() {
+		if err := closeDB(); err != nil {
+			t.Error(err)
+		}
+	})
+	return db
+}
+
+// Run runs the scenario in a fresh database.
+func (s *Server) Run(t testing.TB, sc scenario.Scenario) scenario.Result {
+	t.Helper()
+	r, err := scenario.Run(context.Background(), s.DB, s.DSN, sc)
+	if err != nil {
+		t.Fatalf("unable to run scenario %s: %s", sc.Name, err)
+	}
+	if r.Outcome == scenario.OutcomeSkipped {
+		t.Skipf("scenario %s is not supported by the server", sc.Name)
+	}
+	return r
+}
+
+// ExpectDeadlock runs the scenario and fails the test unless it deadlocked.
+func (s *Server) ExpectDeadlock(t testing.TB, sc scenario.Scenario) scenario.Result {
+	t.Helper()
+	r := s.Run(t, sc)
+	checkDeadlock(t, r)
+	return r
+}
+
+// ExpectBlocked runs the scenario and fails the test unless a step blocked
+// without Postgres reporting a deadlock.
+func (s *Server) ExpectBlocked(t testing.TB, sc scenario.Scenario) scenario.Result {
+	t.Helper()
+	r := s.Run(t, sc)
+	checkBlocked(t, r)
+	return r
+}
+
+// ExpectNoDeadlock runs the scenario and fails the test if it deadlocked or
+// a step blocked.
+func (s *Server) ExpectNoDeadlock(t testing.TB, sc scenario.Scenario) scenario.Result {
+	t.Helper()
+	r := s.Run(t, sc)
+	checkNoDeadlock(t, r)
+	return r
+}
+
+// ExpectDeadlock runs the steps against db and fails the test unless a step
+// failed with deadlock_detected.
+func ExpectDeadlock(t testing.TB, db *sqlx.DB, steps []scenario.Step) scenario.Result {
+	t.Helper()
+	r := runSteps(t, db, steps)
+	checkDeadlock(t, r)
+	return r
+}
+
+// ExpectBlocked runs the steps against db and fails the test unless a step
+// blocked without Postgres reporting a deadlock.
+func ExpectBlocked(t testing.TB, db *sqlx.DB, steps []scenario.Step) scenario.Result {
+	t.Helper()
+	r := runSteps(t, db, steps)
+	checkBlocked(t, r)
+	return r
+}
+
+// ExpectNoDeadlock runs the steps against db and fails the test if a step
+// failed with deadlock_detected or blocked. Other errors, e.g. a
+// unique_violation, are allowed.
+func ExpectNoDeadlock(t testing.TB, db *sqlx.DB, steps []scenario.Step) scenario.Result {
+	t.Helper()
+	r := runSteps(t, db, steps)
+	checkNoDeadlock(t, r)
+	return r
+}
+
+func runSteps(t testing.TB, db *sqlx.DB, steps []scenario.Step) scenario.Result {
+	t.Helper()
+	start := time.Now()
+	results, pids, err := scenario.RunSteps(context.Background(), db, steps)
+	if err != nil {
+		t.Fatalf("unable to run steps: %s", err)
+	}
+	return scenario.Result{
+		Scenario: t.Name(),
+		Outcome:  scenario.Classify(results),
+		Steps:    results,
+		Duration: time.Since(start),
+		PIDs:     pids,
+	}
+}
+
+// deadlocked reports whether a step failed with deadlock_detected, or a
+// cycle Postgres cannot detect was found on the client side.
+func deadlocked(r scenario.Result) bool {
+	for _, s := range r.Steps {
+		if scenario.ErrorCode(s.Err) == "40P01" {
+			return true
+		}
+	}
+	return r.Outcome == scenario.OutcomeAppDeadlock
+}
+
+func checkDeadlock(t testing.TB, r scenario.Result) {
+	t.Helper()
+	if !deadlocked(r) {
+		t.Errorf("expected deadlock_detected (40P01), got %s\n%s", r.Outcome, report(r))
+	}
+}
+
+func checkBlocked(t testing.TB, r scenario.Result) {
+	t.Helper()
+	if deadlocked(r) || r.Outcome != scenario.OutcomeBlocked {
+		t.Errorf("expected a blocked step, got %s\n%s", r.Outcome, report(r))
+	}
+}
+
+func checkNoDeadlock(t testing.TB, r scenario.Result) {
+	t.Helper()
+	if deadlocked(r) || r.Outcome == scenario.OutcomeBlocked {
+		t.Errorf("expected no deadlock, got %s\n%s", r.Outcome, report(r))
+	}
+}
+
+// report formats the steps and the wait-for graphs captured while they ran.
+func report(r scenario.Result) string {
+	var b strings.Builder
+	deadlockreport.WriteResult(&b, r, true)
+	return b.String()
+}
@@ -0,0 +1,57 @@
+package deadlocktest_test
+
+import (
+	"testing"
+
+	"github.com/13rac1/pg-deadlocks/deadlocktest"
+	"github.com/13rac1/pg-deadlocks/pgcontainer"
+	"github.com/13rac1/pg-deadlocks/scenario"
+)
+
+const accounts = `CREATE TABLE accounts (id INT PRIMARY KEY, balance INT);
+	INSERT INTO accounts VALUES (1, 100), (2, 100);`
+
+// TestTransfer is skipped when Docker is not available. Its subtests run in
+// parallel against one server, each in its own database.
+func TestTransfer(t *testing.T) {
+	srv := deadlocktest.Start(t, pgcontainer.Config{})
+
+	t.Run("opposite order", func(t *testing.T) {
+		t.Parallel()
+		db := srv.Database(t, accounts)
+		deadlocktest.ExpectDeadlock(t, db, []scenario.Step{
+			{Session: 0, SQL: `BEGIN`},
+			{Session: 1, SQL: `BEGIN`},
+			{Session: 0, SQL: `UPDATE accounts SET balance = balance - 10 WHERE id = 1`},
+			{Session: 1, SQL: `UPDATE accounts SET balance = balance - 10 WHERE id = 2`},
+			{Session: 0, SQL: `UPDATE accounts SET balance = balance + 10 WHERE id = 2`},
+			{Session: 1, SQL: `UPDATE accounts SET balance = balance + 10 WHERE id = 1`},
+			{Session: 0, SQL: `COMMIT`},
+			{Session: 1, SQL: `COMMIT`},
+		})
+	})
+
+	t.Run("lock ordering", func(t *testing.T) {
+		t.Parallel()
+		db := srv.Database(t, accounts)
+		deadlocktest.ExpectNoDeadlock(t, db, []scenario.Step{
+			{Session: 0, SQL: `BEGIN`},
+			{Session: 1, SQL: `BEGIN`},
+			{Session: 0, SQL: `UPDATE accounts SET balance = balance - 10 WHERE id = 1`},
+			{Session: 1, SQL: `UPDATE accounts SET balance = balance + 10 WHERE id = 1`},
+			{Session: 0, SQL: `UPDATE accounts SET balance = balance + 10 WHERE id = 2`},
+			{Session: 1, SQL: `UPDATE accounts SET balance = balance - 10 WHERE id = 2`},
+			{Session: 0, SQL: `COMMIT`},
+			{Session: 1, SQL: `COMMIT`},
+		})
+	})
+
+	t.Run("bundled scenario", func(t *testing.T) {
+		t.Parallel()
+		s, ok := scenario.Lookup("queue/for-update")
+		if !ok {
+			t.Fatal("queue/for-update is not registered")
+		}
+		srv.ExpectDeadlock(t, s)
+	})
+}
@@ -0,0 +1,53 @@
+package sqlscan
+
+import "strings"
+
+// SplitStatements splits a SQL script into its statements at semicolons
+// which are not inside a quoted string, quoted identifier, dollar quoted
+// string or comment. Comments are kept with the statement which follows them,
+// empty statements are dropped.
+func SplitStatements(script string) []string {
+	var statements []string
+	start := 0
+	add := func(end int) {
+		if s := strings.TrimSpace(script[start:end]); s != "" && !onlyComments(s) {
+			statements = append(statements, s)
+		}
+		start = end + 1
+	}
+	for i := 0; i < len(script); i++ {
+		if end, ok := SkipComment(script, i); ok {
+			i = end
+			continue
+		}
+		switch c := script[i]; {
+		case c == '\'' || c == '"':
+			i = SkipQuoted(script, i, c)
+		case c == '$':
+			if tag, ok := DollarTag(script[i:]); ok {
+				i = SkipDollarQuoted(script, i, tag)
+			}
+		case c == ';':
+			add(i)
+		}
+	}
+	if start < len(script) {
+		add(len(script))
+	}
+	return statements
+}
+
+// onlyComments reports whether a statement has nothing besides comments and
+// white space.
+func onlyComments(statement string) bool {
+	for i := 0; i < len(statement); i++ {
+		if end, ok := SkipComment(statement, i); ok {
+			i = end
+			continue
+		}
+		if c := statement[i]; c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,37 @@
+package sqlscan
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{"statements", "BEGIN; UPDATE a SET x = 1;\nCOMMIT", []string{"BEGIN", "UPDATE a SET x = 1", "COMMIT"}},
+		{"empty statements", ";; BEGIN;\n;\n", []string{"BEGIN"}},
+		{"string", "INSERT INTO a VALUES ('a;b'); SELECT 1", []string{"INSERT INTO a VALUES ('a;b')", "SELECT 1"}},
+		{"doubled quote", "SELECT 'it''s; here'; SELECT 2", []string{"SELECT 'it''s; here'", "SELECT 2"}},
+		{"quoted identifier", `SELECT "a;b" FROM t; SELECT 2`, []string{`SELECT "a;b" FROM t`, "SELECT 2"}},
+		{"line comment", "-- first; statement\nSELECT 1; -- trailing;\nSELECT 2", []string{"-- first; statement\nSELECT 1", "-- trailing;\nSELECT 2"}},
+		{"only a comment", "SELECT 1;\n-- done", []string{"SELECT 1"}},
+		{"block comment", "SELECT /* a; b */ 1; SELECT 2", []string{"SELECT /* a; b */ 1", "SELECT 2"}},
+		{"only a block comment", "SELECT 1; /* done;\n */", []string{"SELECT 1"}},
+		{"dollar quote", "CREATE FUNCTION f() RETURNS void AS $$ BEGIN; END; $$ LANGUAGE plpgsql; SELECT f()",
+			[]string{"CREATE FUNCTION f() RETURNS void AS $$ BEGIN; END; $$ LANGUAGE plpgsql", "SELECT f()"}},
+		{"dollar tag", "DO $body$ BEGIN PERFORM '$$;'; END $body$; SELECT 1",
+			[]string{"DO $body$ BEGIN PERFORM '$$;'; END $body$", "SELECT 1"}},
+		{"parameter", "PREPARE p AS SELECT $1; EXECUTE p(1)", []string{"PREPARE p AS SELECT $1", "EXECUTE p(1)"}},
+		{"parameters", "SELECT $1, $2; SELECT 1", []string{"SELECT $1, $2", "SELECT 1"}},
+		{"unterminated string", "SELECT 'a; b", []string{"SELECT 'a; b"}},
+		{"unterminated dollar quote", "DO $$ BEGIN; END", []string{"DO $$ BEGIN; END"}},
+	}
+	for _, tt := range tests {
+		if got := SplitStatements(tt.script); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: SplitStatements(%q) = %q, want %q", tt.name, tt.script, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Package sqlscan splits SQL scripts into statements. Its lexical rules of
+// SQL, where quoted strings, dollar quoted strings and comments end, are
+// shared with the tokenizer of lockanalysis.
+package sqlscan
+
+import "strings"
+
+// SkipQuoted returns the index of the quote closing the string starting at
+// i, a doubled quote is part of the string. It returns len(s) when the
+// string is not closed.
+func SkipQuoted(s string, i int, quote byte) int {
+	for j := i + 1; j < len(s); j++ {
+		if s[j] != quote {
+			continue
+		}
+		if j+1 < len(s) && s[j+1] == quote {
+			j++
+			continue
+		}
+		return j
+	}
+	return len(s)
+}
+
+// DollarTag returns the $tag$ starting s, e.g. $$ or $body$. Parameters like
+// $1 are not tags.
+func DollarTag(s string) (string, bool) {
+	for j := 1; j < len(s); j++ {
+		c := s[j]
+		switch {
+		case c == '$':
+			return s[:j+1], true
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || j > 1 && c >= '0' && c <= '9':
+		default:
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// SkipDollarQuoted returns the index of the last character of the tag closing
+// the dollar quoted string starting with tag at i, or len(s) when it is not
+// closed.
+func SkipDollarQuoted(s string, i int, tag string) int {
+	if end := strings.Index(s[i+len(tag):], tag); end >= 0 {
+		return i + len(tag) + end + len(tag) - 1
+	}
+	return len(s)
+}
+
+// SkipComment returns the index of the last character of the -- or /* */
+// comment starting at i, the newline ending a line comment. It reports false
+// when no comment starts at i.
+func SkipComment(s string, i int) (int, bool) {
+	switch {
+	case strings.HasPrefix(s[i:], "--"):
+		if end := strings.IndexByte(s[i:], '\n'); end >= 0 {
+			return i + end, true
+		}
+		return len(s), true
+	case strings.HasPrefix(s[i:], "/*"):
+		if end := strings.Index(s[i+2:], "*/"); end >= 0 {
+			return i + end + 3, true
+		}
+		return len(s), true
+	}
+	return i, false
+}
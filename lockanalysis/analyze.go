@@ -0,0 +1,676 @@
+// Package lockanalysis predicts the locks a SQL statement takes without
+// running it: the table level lock mode on every table it names and the
+// strength of the row locks it takes, for a given server version. The
+// predictions are checked against pg_locks by Verify.
+package lockanalysis
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/13rac1/pg-deadlocks/lockmon"
+)
+
+// Row lock strengths, see
+// https://www.postgresql.org/docs/current/explicit-locking.html#LOCKING-ROWS
+const (
+	ForKeyShare    = "FOR KEY SHARE"
+	ForShare       = "FOR SHARE"
+	ForNoKeyUpdate = "FOR NO KEY UPDATE"
+	ForUpdate      = "FOR UPDATE"
+)
+
+// Server versions changing the lock modes of statements, in the format of
+// server_version_num.
+const (
+	version93 = 90300
+	version94 = 90400
+	version95 = 90500
+	version10 = 100000
+	version12 = 120000
+	version14 = 140000
+)
+
+// TableLock is a table level lock mode taken on a table.
+type TableLock struct {
+	Table string
+	Mode  string
+}
+
+func (l TableLock) String() string {
+	return fmt.Sprintf("%s on %s", l.Mode, l.Table)
+}
+
+// RowLock is the strength of the row locks taken on rows of a table, either
+// explicitly by a locking clause or by UPDATE and DELETE.
+type RowLock struct {
+	Table    string
+	Strength string
+}
+
+func (l RowLock) String() string {
+	return fmt.Sprintf("%s of %s", l.Strength, l.Table)
+}
+
+// Statement is the prediction of the locks taken by a statement.
+type Statement struct {
+	SQL string
+	// Tables has one lock per table in the order the tables are first
+	// locked, with the strongest mode the statement takes on the table.
+	// Tables are named without their schema.
+	Tables []TableLock
+	Rows   []RowLock
+	// Known is false when the statement is not understood, its locks are
+	// then unknown rather than none.
+	Known bool
+	// Transactional is false for statements which cannot run inside a
+	// transaction block, like VACUUM and CREATE INDEX CONCURRENTLY.
+	Transactional bool
+}
+
+// Mode returns the mode the statement takes on table, or "" if it does not
+// lock the table.
+func (s Statement) Mode(table string) string {
+	for _, l := range s.Tables {
+		if l.Table == table {
+			return l.Mode
+		}
+	}
+	return ""
+}
+
+func (s *Statement) lock(table, mode string) {
+	for i, l := range s.Tables {
+		if l.Table == table {
+			s.Tables[i].Mode = Strongest(l.Mode, mode)
+			return
+		}
+	}
+	s.Tables = append(s.Tables, TableLock{table, mode})
+}
+
+func (s *Statement) lockRows(table, strength string) {
+	for _, l := range s.Rows {
+		if l.Table == table && l.Strength == strength {
+			return
+		}
+	}
+	s.Rows = append(s.Rows, RowLock{table, strength})
+}
+
+// tableLockModes are the table lock modes from the weakest to the strongest.
+var tableLockModes = []string{
+	"AccessShareLock",
+	"RowShareLock",
+	"RowExclusiveLock",
+	"ShareUpdateExclusiveLock",
+	"ShareLock",
+	"ShareRowExclusiveLock",
+	"ExclusiveLock",
+	"AccessExclusiveLock",
+}
+
+// Strongest returns the weakest table lock mode conflicting with every mode a
+// or b conflicts with. That is the stronger of the two modes, except for
+// pairs where neither conflicts with everything the other does, like
+// ShareLock and ShareUpdateExclusiveLock or ShareLock and RowExclusiveLock,
+// which combine to ShareRowExclusiveLock. An empty mode is no lock.
+func Strongest(a, b string) string {
+	if a == "" {
+		return b
+	}
+	if b == "" {
+		return a
+	}
+	for _, mode := range tableLockModes {
+		if conflictsWithAll(mode, a) && conflictsWithAll(mode, b) {
+			return mode
+		}
+	}
+	return "AccessExclusiveLock"
+}
+
+// conflictsWithAll reports whether mode conflicts with every mode other
+// conflicts with.
+func conflictsWithAll(mode, other string) bool {
+	for _, m := range lockmon.LockConflicts[other] {
+		if !lockmon.Conflicts(mode, m) {
+			return false
+		}
+	}
+	return true
+}
+
+// Analyze predicts the locks taken by a single statement on a server of the
+// given server_version_num. Locks taken on behalf of the statement by
+// triggers, foreign keys, rules and the queries of views and functions are
+// not predicted. UPDATE is predicted to lock rows FOR NO KEY UPDATE since
+// 9.3, the schema is not known to tell when the SET columns are part of a
+// unique index and Postgres takes FOR UPDATE instead.
+func Analyze(query string, version int) Statement {
+	s := Statement{SQL: query, Known: true, Transactional: true}
+	p := newParser(query)
+	switch {
+	case p.done():
+	case p.peek("BEGIN"), p.peek("START"), p.peek("COMMIT"), p.peek("END"),
+		p.peek("ROLLBACK"), p.peek("ABORT"), p.peek("SAVEPOINT"), p.peek("RELEASE"),
+		p.peek("PREPARE"), p.peek("SET"), p.peek("SHOW"), p.peek("RESET"):
+	case p.peek("SELECT"), p.peek("WITH"), p.peek("INSERT"), p.peek("UPDATE"),
+		p.peek("DELETE"), p.peek("MERGE"), p.peek("VALUES"), p.peek("TABLE"), p.peek("("):
+		scanDML(p, &s, version)
+	case p.accept("TRUNCATE"):
+		p.accept("TABLE")
+		for _, t := range nameList(p) {
+			s.lock(t, "AccessExclusiveLock")
+		}
+	case p.accept("LOCK"):
+		analyzeLock(p, &s)
+	case p.accept("DROP"):
+		analyzeDrop(p, &s)
+	case p.accept("CREATE"):
+		analyzeCreate(p, &s, version)
+	case p.accept("ALTER", "TABLE"):
+		analyzeAlterTable(p, &s, version)
+	case p.accept("VACUUM"):
+		s.Transactional = false
+		mode := "ShareUpdateExclusiveLock"
+		for !p.done() && (p.peek("FULL") || p.peek("FREEZE") || p.peek("VERBOSE") || p.peek("ANALYZE") || p.peek("ANALYSE") || p.peek("(")) {
+			if p.peek("FULL") || p.peek("(") && p.at(p.pos+1, "FULL") {
+				mode = "AccessExclusiveLock"
+			}
+			if p.peek("(") {
+				p.skipParens()
+			} else {
+				p.pos++
+			}
+		}
+		for _, t := range nameList(p) {
+			s.lock(t, mode)
+		}
+	case p.accept("ANALYZE"), p.accept("ANALYSE"):
+		p.accept("VERBOSE")
+		p.skipParens()
+		for _, t := range nameList(p) {
+			s.lock(t, "ShareUpdateExclusiveLock")
+		}
+	case p.accept("CLUSTER"):
+		p.accept("VERBOSE")
+		if t, ok := p.name(); ok {
+			s.lock(t, "AccessExclusiveLock")
+		} else {
+			s.Transactional = false
+		}
+	case p.accept("REINDEX"):
+		analyzeReindex(p, &s, version)
+	case p.accept("REFRESH", "MATERIALIZED", "VIEW"):
+		mode := "AccessExclusiveLock"
+		if p.accept("CONCURRENTLY") {
+			mode = "ExclusiveLock"
+		}
+		if t, ok := p.name(); ok {
+			s.lock(t, mode)
+		}
+	default:
+		s.Known = false
+	}
+	return s
+}
+
+// nameList consumes a comma separated list of table names, each optionally
+// preceded by ONLY, followed by * or by a column list.
+func nameList(p *parser) []string {
+	var names []string
+	for {
+		p.accept("ONLY")
+		name, ok := p.name()
+		if !ok {
+			return names
+		}
+		names = append(names, name)
+		p.accept("*")
+		p.skipParens()
+		if !p.accept(",") {
+			return names
+		}
+	}
+}
+
+// lockModeWords are the modes of LOCK TABLE ... IN mode MODE.
+var lockModeWords = map[string]string{
+	"ACCESS SHARE":           "AccessShareLock",
+	"ROW SHARE":              "RowShareLock",
+	"ROW EXCLUSIVE":          "RowExclusiveLock",
+	"SHARE UPDATE EXCLUSIVE": "ShareUpdateExclusiveLock",
+	"SHARE":                  "ShareLock",
+	"SHARE ROW EXCLUSIVE":    "ShareRowExclusiveLock",
+	"EXCLUSIVE":              "ExclusiveLock",
+	"ACCESS EXCLUSIVE":       "AccessExclusiveLock",
+}
+
+func analyzeLock(p *parser, s *Statement) {
+	p.accept("TABLE")
+	tables := nameList(p)
+	mode := "AccessExclusiveLock"
+	if p.accept("IN") {
+		var words []string
+		for !p.done() && !p.peek("MODE") {
+			words = append(words, p.tokens[p.pos].text)
+			p.pos++
+		}
+		mode = lockModeWords[strings.Join(words, " ")]
+		if mode == "" {
+			s.Known = false
+			return
+		}
+	}
+	for _, t := range tables {
+		s.lock(t, mode)
+	}
+}
+
+func analyzeDrop(p *parser, s *Statement) {
+	mode := "AccessExclusiveLock"
+	switch {
+	case p.accept("TABLE"), p.accept("VIEW"), p.accept("MATERIALIZED", "VIEW"):
+	case p.accept("INDEX"):
+		// The table of the index is locked too, but cannot be named without
+		// the catalog.
+		if p.accept("CONCURRENTLY") {
+			mode = "ShareUpdateExclusiveLock"
+			s.Transactional = false
+		}
+	default:
+		s.Known = false
+		return
+	}
+	p.accept("IF", "EXISTS")
+	for _, t := range nameList(p) {
+		s.lock(t, mode)
+	}
+}
+
+func analyzeCreate(p *parser, s *Statement, version int) {
+	p.accept("OR", "REPLACE")
+	for p.accept("UNIQUE") || p.accept("TEMP") || p.accept("TEMPORARY") ||
+		p.accept("UNLOGGED") || p.accept("CONSTRAINT") || p.accept("GLOBAL") || p.accept("LOCAL") {
+	}
+	switch {
+	case p.accept("INDEX"):
+		mode := "ShareLock"
+		if p.accept("CONCURRENTLY") {
+			mode = "ShareUpdateExclusiveLock"
+			s.Transactional = false
+		}
+		if !p.skipTo("ON") {
+			s.Known = false
+			return
+		}
+		p.accept("ON")
+		p.accept("ONLY")
+		if t, ok := p.name(); ok {
+			s.lock(t, mode)
+		}
+	case p.accept("TRIGGER"):
+		if !p.skipTo("ON") {
+			s.Known = false
+			return
+		}
+		p.accept("ON")
+		if t, ok := p.name(); ok {
+			s.lock(t, foreignKeyMode(version))
+		}
+	case p.accept("TABLE"):
+		p.accept("IF", "NOT", "EXISTS")
+		if t, ok := p.name(); ok {
+			s.lock(t, "AccessExclusiveLock")
+		}
+		for i := p.pos; i < len(p.tokens); i++ {
+			switch {
+			case p.at(i, "REFERENCES"):
+				p.pos = i + 1
+				if t, ok := p.name(); ok {
+					s.lock(t, foreignKeyMode(version))
+				}
+			case p.at(i, "PARTITION", "OF"):
+				p.pos = i + 2
+				if t, ok := p.name(); ok {
+					s.lock(t, "AccessExclusiveLock")
+				}
+			}
+		}
+		p.pos = 0
+		scanDML(p, s, version)
+	case p.accept("MATERIALIZED", "VIEW"), p.accept("VIEW"):
+		p.accept("IF", "NOT", "EXISTS")
+		if t, ok := p.name(); ok {
+			s.lock(t, "AccessExclusiveLock")
+		}
+		scanDML(p, s, version)
+	case p.accept("STATISTICS"):
+		if version < version10 || !p.skipTo("FROM") {
+			s.Known = false
+			return
+		}
+		p.accept("FROM")
+		if t, ok := p.name(); ok {
+			s.lock(t, "ShareUpdateExclusiveLock")
+		}
+	case p.accept("FUNCTION"), p.accept("PROCEDURE"), p.accept("SCHEMA"),
+		p.accept("TYPE"), p.accept("SEQUENCE"), p.accept("EXTENSION"), p.accept("DOMAIN"):
+	default:
+		s.Known = false
+	}
+}
+
+// foreignKeyMode is the mode CREATE TRIGGER, and adding a foreign key, take
+// on the table and the referenced table.
+func foreignKeyMode(version int) string {
+	if version < version95 {
+		return "AccessExclusiveLock"
+	}
+	return "ShareRowExclusiveLock"
+}
+
+// analyzeAlterTable predicts the mode of every subcommand, the table is locked
+// with the strongest of them. See AlterTableGetLockLevel() in tablecmds.c.
+func analyzeAlterTable(p *parser, s *Statement, version int) {
+	p.accept("IF", "EXISTS")
+	p.accept("ONLY")
+	table, ok := p.name()
+	if !ok {
+		s.Known = false
+		return
+	}
+	p.accept("*")
+	mode := ""
+	for !p.done() {
+		start := p.pos
+		p.skipTo(",")
+		sub := &parser{tokens: p.tokens[start:p.pos]}
+		p.accept(",")
+		mode = Strongest(mode, alterTableMode(sub, s, table, version))
+	}
+	if mode == "" {
+		s.Known = false
+		return
+	}
+	s.Tables = append([]TableLock{{table, mode}}, s.Tables...)
+}
+
+func alterTableMode(p *parser, s *Statement, table string, version int) string {
+	sue := "ShareUpdateExclusiveLock"
+	switch {
+	case p.peek("VALIDATE", "CONSTRAINT") && version >= version94,
+		p.peek("CLUSTER", "ON") && version >= version94,
+		p.peek("SET", "WITHOUT", "CLUSTER") && version >= version94,
+		(p.peek("SET", "(") || p.peek("RESET", "(")) && version >= version10:
+		return sue
+	case p.peek("ENABLE"), p.peek("DISABLE"):
+		if p.skipTo("TRIGGER") {
+			return foreignKeyMode(version)
+		}
+	case p.accept("ATTACH", "PARTITION"):
+		if t, ok := p.name(); ok {
+			s.lock(t, "AccessExclusiveLock")
+		}
+		if version >= version12 {
+			return sue
+		}
+		return "AccessExclusiveLock"
+	case p.accept("DETACH", "PARTITION"):
+		if t, ok := p.name(); ok {
+			s.lock(t, "AccessExclusiveLock")
+		}
+		if p.accept("CONCURRENTLY") && version >= version14 {
+			s.Transactional = false
+			return sue
+		}
+		return "AccessExclusiveLock"
+	case p.accept("ALTER"):
+		p.accept("COLUMN")
+		p.name()
+		if (p.peek("SET", "STATISTICS") || p.peek("SET", "(") || p.peek("RESET", "(")) && version >= version94 {
+			return sue
+		}
+	case p.accept("ADD"):
+		foreignKey := false
+		for i := p.pos; i < len(p.tokens); i++ {
+			if p.at(i, "FOREIGN", "KEY") {
+				foreignKey = true
+			}
+			if p.at(i, "REFERENCES") {
+				p.pos = i + 1
+				if t, ok := p.name(); ok && t != table {
+					s.lock(t, foreignKeyMode(version))
+				}
+			}
+		}
+		if foreignKey {
+			return foreignKeyMode(version)
+		}
+	}
+	return "AccessExclusiveLock"
+}
+
+func analyzeReindex(p *parser, s *Statement, version int) {
+	p.skipParens()
+	switch {
+	case p.accept("TABLE"):
+		mode := "ShareLock"
+		if p.accept("CONCURRENTLY") && version >= version12 {
+			mode = "ShareUpdateExclusiveLock"
+			s.Transactional = false
+		}
+		if t, ok := p.name(); ok {
+			s.lock(t, mode)
+		}
+	case p.accept("INDEX"):
+		// The table of the index is locked with ShareLock, but cannot be
+		// named without the catalog.
+		mode := "AccessExclusiveLock"
+		if p.accept("CONCURRENTLY") && version >= version12 {
+			mode = "ShareUpdateExclusiveLock"
+			s.Transactional = false
+		}
+		if t, ok := p.name(); ok {
+			s.lock(t, mode)
+		}
+	default:
+		s.Known = false
+		s.Transactional = false
+	}
+}
+
+// tableRef is a table read by a query.
+type tableRef struct {
+	table, alias string
+	depth        int
+}
+
+// scanDML predicts the locks of queries: RowExclusiveLock on the targets of
+// INSERT, UPDATE, DELETE and MERGE, AccessShareLock on the tables read, and
+// RowShareLock and row locks on the tables of locking clauses. Data modifying
+// WITH queries and subqueries are scanned the same way.
+func scanDML(p *parser, s *Statement, version int) {
+	updateRows := ForNoKeyUpdate
+	if version < version93 {
+		updateRows = ForUpdate
+	}
+	ctes := map[string]bool{}
+	for i := range p.tokens {
+		if p.tokens[i].name != "" && (p.at(i+1, "AS", "(") || p.at(i+1, "AS", "MATERIALIZED", "(") || p.at(i+1, "AS", "NOT", "MATERIALIZED", "(")) {
+			ctes[p.tokens[i].name] = true
+		}
+	}
+	var (
+		refs        []tableRef
+		selectStart = map[int]int{}
+		// functions are the words before every open parenthesis.
+		functions []string
+		target    string
+	)
+	read := func(table, alias string) {
+		if ctes[table] {
+			return
+		}
+		refs = append(refs, tableRef{table, alias, len(functions)})
+		s.lock(table, "AccessShareLock")
+	}
+	// fromList consumes the table references of a FROM or USING clause, or
+	// the single reference of a JOIN.
+	fromList := func(single bool) {
+		for {
+			p.accept("ONLY")
+			p.accept("LATERAL")
+			if p.peek("(") {
+				return
+			}
+			table, ok := p.name()
+			if !ok {
+				return
+			}
+			if p.peek("(") {
+				// A set returning function.
+				return
+			}
+			p.accept("*")
+			alias := table
+			p.accept("AS")
+			if !p.done() && p.tokens[p.pos].name != "" && !clauseKeywords[p.tokens[p.pos].text] {
+				alias = p.tokens[p.pos].name
+				p.pos++
+			}
+			read(table, alias)
+			if single || !p.accept(",") {
+				return
+			}
+		}
+	}
+	for p.pos < len(p.tokens) {
+		i := p.pos
+		t := p.tokens[i].text
+		p.pos++
+		switch {
+		case t == "(":
+			word := ""
+			if i > 0 {
+				word = p.tokens[i-1].text
+			}
+			functions = append(functions, word)
+		case t == ")":
+			if len(functions) > 0 {
+				functions = functions[:len(functions)-1]
+			}
+		case t == "SELECT":
+			selectStart[len(functions)] = len(refs)
+		case t == "INSERT" && p.accept("INTO"), t == "MERGE" && p.accept("INTO"):
+			if table, ok := p.name(); ok {
+				target = table
+				s.lock(table, "RowExclusiveLock")
+			}
+		case t == "UPDATE" && p.at(i-1, "DO"):
+			// INSERT ... ON CONFLICT DO UPDATE locks the conflicting row.
+			if target != "" {
+				s.lockRows(target, updateRows)
+			}
+		case t == "UPDATE" && !p.at(i-1, "FOR") && !p.at(i-1, "KEY"):
+			// FOR UPDATE when a key column is SET, which needs the schema.
+			p.accept("ONLY")
+			if table, ok := p.name(); ok {
+				target = table
+				s.lock(table, "RowExclusiveLock")
+				s.lockRows(table, updateRows)
+			}
+		case t == "DELETE" && p.accept("FROM"):
+			p.accept("ONLY")
+			if table, ok := p.name(); ok {
+				target = table
+				s.lock(table, "RowExclusiveLock")
+				s.lockRows(table, ForUpdate)
+			}
+		case t == "FROM" && !p.at(i-1, "DISTINCT") && !inFunction(functions):
+			fromList(false)
+		case t == "USING" && !p.peek("(") && !inFunction(functions):
+			fromList(false)
+		case t == "JOIN":
+			fromList(true)
+		case t == "FOR" && (p.peek("UPDATE") || p.peek("SHARE") || p.peek("NO", "KEY", "UPDATE") || p.peek("KEY", "SHARE")):
+			strength := "FOR " + p.tokens[p.pos].text
+			switch {
+			case p.accept("NO", "KEY", "UPDATE"):
+				strength = ForNoKeyUpdate
+			case p.accept("KEY", "SHARE"):
+				strength = ForKeyShare
+			default:
+				p.pos++
+			}
+			depth := len(functions)
+			locked := refs[selectStart[depth]:]
+			if p.accept("OF") {
+				var of []tableRef
+				for {
+					alias, ok := p.name()
+					if !ok {
+						break
+					}
+					for _, r := range locked {
+						if r.alias == alias && r.depth == depth {
+							of = append(of, r)
+						}
+					}
+					if !p.accept(",") {
+						break
+					}
+				}
+				locked = of
+			}
+			for _, r := range locked {
+				if r.depth == depth {
+					s.lock(r.table, "RowShareLock")
+					s.lockRows(r.table, strength)
+				}
+			}
+		}
+	}
+}
+
+// inFunction reports whether the innermost parenthesis belongs to a function
+// whose arguments are separated by FROM or USING, like EXTRACT(field FROM x).
+func inFunction(functions []string) bool {
+	if len(functions) == 0 {
+		return false
+	}
+	switch functions[len(functions)-1] {
+	case "EXTRACT", "SUBSTRING", "TRIM", "OVERLAY", "POSITION", "CONVERT":
+		return true
+	}
+	return false
+}
+
+// clauseKeywords end a table reference, they are not an alias.
+var clauseKeywords = map[string]bool{
+	"WHERE": true, "JOIN": true, "LEFT": true, "RIGHT": true, "INNER": true,
+	"FULL": true, "CROSS": true, "NATURAL": true, "ON": true, "USING": true,
+	"GROUP": true, "HAVING": true, "WINDOW": true, "ORDER": true, "LIMIT": true,
+	"OFFSET": true, "FETCH": true, "FOR": true, "UNION": true, "EXCEPT": true,
+	"INTERSECT": true, "SET": true, "RETURNING": true, "VALUES": true,
+	"SELECT": true, "DEFAULT": true, "OVERRIDING": true, "WHEN": true, "TABLESAMPLE": true, "LATERAL": true,
+}
+
+// String summarizes the predicted locks.
+func (s Statement) String() string {
+	if !s.Known {
+		return "unknown"
+	}
+	var locks []string
+	for _, l := range s.Tables {
+		locks = append(locks, l.String())
+	}
+	for _, l := range s.Rows {
+		locks = append(locks, "rows "+l.String())
+	}
+	if len(locks) == 0 {
+		return "none"
+	}
+	return strings.Join(locks, ", ")
+}
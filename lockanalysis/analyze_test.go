@@ -0,0 +1,169 @@
+package lockanalysis
+
+import "testing"
+
+// exampleLocks has the predicted locks of every example on 9.2, 9.4 and 14,
+// an empty string is the locks of the previous version.
+var exampleLocks = map[string][3]string{
+	"SELECT * FROM accounts WHERE id = 1": {
+		"AccessShareLock on accounts"},
+	"SELECT * FROM accounts a JOIN transfers t ON t.account_id = a.id": {
+		"AccessShareLock on accounts, AccessShareLock on transfers"},
+	"SELECT * FROM accounts WHERE id IN (SELECT account_id FROM transfers)": {
+		"AccessShareLock on accounts, AccessShareLock on transfers"},
+	"SELECT * FROM accounts WHERE id = 1 FOR UPDATE": {
+		"RowShareLock on accounts, rows FOR UPDATE of accounts"},
+	"SELECT * FROM accounts WHERE id = 1 FOR NO KEY UPDATE": {
+		"RowShareLock on accounts, rows FOR NO KEY UPDATE of accounts"},
+	"SELECT * FROM accounts WHERE id = 1 FOR SHARE": {
+		"RowShareLock on accounts, rows FOR SHARE of accounts"},
+	"SELECT * FROM accounts WHERE id = 1 FOR KEY SHARE": {
+		"RowShareLock on accounts, rows FOR KEY SHARE of accounts"},
+	"SELECT * FROM accounts a JOIN transfers t ON t.account_id = a.id FOR UPDATE OF a": {
+		"RowShareLock on accounts, AccessShareLock on transfers, rows FOR UPDATE of accounts"},
+	"SELECT * FROM accounts WHERE id IN (SELECT account_id FROM transfers) FOR UPDATE SKIP LOCKED": {
+		"RowShareLock on accounts, AccessShareLock on transfers, rows FOR UPDATE of accounts"},
+	"INSERT INTO transfers (account_id, amount) VALUES (1, 10)": {
+		"RowExclusiveLock on transfers"},
+	"INSERT INTO transfers (account_id, amount) SELECT id, 1 FROM accounts": {
+		"RowExclusiveLock on transfers, AccessShareLock on accounts"},
+	"INSERT INTO accounts VALUES (1, 0) ON CONFLICT (id) DO UPDATE SET balance = EXCLUDED.balance": {
+		"RowExclusiveLock on accounts, rows FOR UPDATE of accounts",
+		"RowExclusiveLock on accounts, rows FOR NO KEY UPDATE of accounts"},
+	"UPDATE accounts SET balance = balance + 1 WHERE id = 1": {
+		"RowExclusiveLock on accounts, rows FOR UPDATE of accounts",
+		"RowExclusiveLock on accounts, rows FOR NO KEY UPDATE of accounts"},
+	"UPDATE accounts SET balance = 0 FROM transfers WHERE transfers.account_id = accounts.id": {
+		"RowExclusiveLock on accounts, AccessShareLock on transfers, rows FOR UPDATE of accounts",
+		"RowExclusiveLock on accounts, AccessShareLock on transfers, rows FOR NO KEY UPDATE of accounts"},
+	"DELETE FROM transfers WHERE account_id = 1": {
+		"RowExclusiveLock on transfers, rows FOR UPDATE of transfers"},
+	"DELETE FROM transfers USING accounts WHERE accounts.id = transfers.account_id": {
+		"RowExclusiveLock on transfers, AccessShareLock on accounts, rows FOR UPDATE of transfers"},
+	"WITH moved AS (DELETE FROM transfers RETURNING account_id) UPDATE accounts SET balance = 0 WHERE id IN (SELECT account_id FROM moved)": {
+		"RowExclusiveLock on transfers, RowExclusiveLock on accounts, rows FOR UPDATE of transfers, rows FOR UPDATE of accounts",
+		"RowExclusiveLock on transfers, RowExclusiveLock on accounts, rows FOR UPDATE of transfers, rows FOR NO KEY UPDATE of accounts"},
+	"TRUNCATE transfers": {
+		"AccessExclusiveLock on transfers"},
+	"LOCK TABLE accounts IN SHARE ROW EXCLUSIVE MODE": {
+		"ShareRowExclusiveLock on accounts"},
+	"LOCK accounts": {
+		"AccessExclusiveLock on accounts"},
+	"CREATE INDEX ON transfers (account_id)": {
+		"ShareLock on transfers"},
+	"CREATE INDEX CONCURRENTLY ON transfers (amount)": {
+		"ShareUpdateExclusiveLock on transfers"},
+	"REINDEX TABLE transfers": {
+		"ShareLock on transfers"},
+	"VACUUM transfers": {
+		"ShareUpdateExclusiveLock on transfers"},
+	"ANALYZE transfers": {
+		"ShareUpdateExclusiveLock on transfers"},
+	"CLUSTER accounts USING accounts_pkey": {
+		"AccessExclusiveLock on accounts"},
+	"CREATE TRIGGER noop BEFORE INSERT ON transfers FOR EACH ROW EXECUTE PROCEDURE noop()": {
+		"AccessExclusiveLock on transfers", "",
+		"ShareRowExclusiveLock on transfers"},
+	"CREATE TABLE audits (id INT REFERENCES accounts (id))": {
+		"AccessExclusiveLock on audits, AccessExclusiveLock on accounts", "",
+		"AccessExclusiveLock on audits, ShareRowExclusiveLock on accounts"},
+	"CREATE TABLE accounts_copy AS SELECT * FROM accounts": {
+		"AccessExclusiveLock on accounts_copy, AccessShareLock on accounts"},
+	"ALTER TABLE transfers ADD COLUMN note TEXT": {
+		"AccessExclusiveLock on transfers"},
+	"ALTER TABLE transfers ALTER COLUMN amount TYPE BIGINT": {
+		"AccessExclusiveLock on transfers"},
+	"ALTER TABLE transfers ADD CONSTRAINT transfers_account_fkey FOREIGN KEY (account_id) REFERENCES accounts (id)": {
+		"AccessExclusiveLock on transfers, AccessExclusiveLock on accounts", "",
+		"ShareRowExclusiveLock on transfers, ShareRowExclusiveLock on accounts"},
+	"ALTER TABLE transfers ADD CONSTRAINT amount_small CHECK (amount < 1000) NOT VALID": {
+		"AccessExclusiveLock on transfers"},
+	"ALTER TABLE transfers VALIDATE CONSTRAINT amount_positive": {
+		"AccessExclusiveLock on transfers",
+		"ShareUpdateExclusiveLock on transfers"},
+	"ALTER TABLE transfers ALTER COLUMN amount SET STATISTICS 500": {
+		"AccessExclusiveLock on transfers",
+		"ShareUpdateExclusiveLock on transfers"},
+	"ALTER TABLE accounts CLUSTER ON accounts_pkey": {
+		"AccessExclusiveLock on accounts",
+		"ShareUpdateExclusiveLock on accounts"},
+	"ALTER TABLE transfers DISABLE TRIGGER ALL": {
+		"AccessExclusiveLock on transfers", "",
+		"ShareRowExclusiveLock on transfers"},
+	"DROP TABLE transfers": {
+		"AccessExclusiveLock on transfers"},
+}
+
+func TestAnalyzeExamples(t *testing.T) {
+	versions := [3]int{90200, 90400, 140000}
+	for _, query := range Examples {
+		locks, ok := exampleLocks[query]
+		if !ok {
+			t.Errorf("no expected locks for example %q", query)
+			continue
+		}
+		want := ""
+		for i, version := range versions {
+			if locks[i] != "" {
+				want = locks[i]
+			}
+			s := Analyze(query, version)
+			if got := s.String(); got != want {
+				t.Errorf("Analyze(%q, %d) = %s, want %s", query, version, got, want)
+			}
+			nonTransactional := query == "VACUUM transfers" || query == "CREATE INDEX CONCURRENTLY ON transfers (amount)"
+			if s.Transactional == nonTransactional {
+				t.Errorf("Analyze(%q, %d).Transactional = %t", query, version, s.Transactional)
+			}
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "none"},
+		{"BEGIN", "none"},
+		{"/* ; */ UPDATE \"Accounts\" SET note = $$;$$ -- WHERE", "RowExclusiveLock on Accounts, rows FOR NO KEY UPDATE of Accounts"},
+		{"SELECT 'FROM accounts' FROM transfers", "AccessShareLock on transfers"},
+		{"SELECT extract(year FROM now()) FROM transfers", "AccessShareLock on transfers"},
+		{"WITH t AS (SELECT 1) SELECT * FROM t", "none"},
+		{"ALTER TABLE transfers VALIDATE CONSTRAINT amount_positive, ADD COLUMN note TEXT", "AccessExclusiveLock on transfers"},
+		{"ALTER TABLE transfers VALIDATE CONSTRAINT amount_positive, SET (fillfactor = 70)", "ShareUpdateExclusiveLock on transfers"},
+		{"LOCK TABLE accounts, transfers IN SHARE MODE", "ShareLock on accounts, ShareLock on transfers"},
+		{"FROBNICATE accounts", "unknown"},
+	}
+	for _, tt := range tests {
+		if got := Analyze(tt.query, 140000).String(); got != tt.want {
+			t.Errorf("Analyze(%q) = %s, want %s", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestStrongest(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"", "", ""},
+		{"", "RowExclusiveLock", "RowExclusiveLock"},
+		{"AccessShareLock", "", "AccessShareLock"},
+		{"AccessShareLock", "AccessShareLock", "AccessShareLock"},
+		{"AccessShareLock", "RowExclusiveLock", "RowExclusiveLock"},
+		{"AccessExclusiveLock", "RowShareLock", "AccessExclusiveLock"},
+		{"ShareUpdateExclusiveLock", "ShareRowExclusiveLock", "ShareRowExclusiveLock"},
+		{"ShareLock", "ShareUpdateExclusiveLock", "ShareRowExclusiveLock"},
+		{"ShareUpdateExclusiveLock", "ShareLock", "ShareRowExclusiveLock"},
+		{"RowExclusiveLock", "ShareLock", "ShareRowExclusiveLock"},
+		{"ShareLock", "RowExclusiveLock", "ShareRowExclusiveLock"},
+		{"RowExclusiveLock", "ShareUpdateExclusiveLock", "ShareUpdateExclusiveLock"},
+		{"ShareLock", "ExclusiveLock", "ExclusiveLock"},
+	}
+	for _, tt := range tests {
+		if got := Strongest(tt.a, tt.b); got != tt.want {
+			t.Errorf("Strongest(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
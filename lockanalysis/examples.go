@@ -0,0 +1,54 @@
+package lockanalysis
+
+// ExampleSetup creates the tables of Examples.
+var ExampleSetup = []string{`
+CREATE TABLE accounts (id INT PRIMARY KEY, balance INT NOT NULL);
+CREATE TABLE transfers (id SERIAL PRIMARY KEY, account_id INT NOT NULL, amount INT NOT NULL);
+INSERT INTO accounts SELECT id, 100 FROM generate_series(1, 10) id;
+INSERT INTO transfers (account_id, amount) SELECT id, 10 FROM generate_series(1, 10) id;
+ALTER TABLE transfers ADD CONSTRAINT amount_positive CHECK (amount > 0) NOT VALID;
+CREATE FUNCTION noop() RETURNS trigger LANGUAGE plpgsql AS $$ BEGIN RETURN NEW; END $$;`,
+}
+
+// Examples are statements covering the locks predicted by Analyze, run by
+// lockverify.Verify on the tables of ExampleSetup.
+var Examples = []string{
+	"SELECT * FROM accounts WHERE id = 1",
+	"SELECT * FROM accounts a JOIN transfers t ON t.account_id = a.id",
+	"SELECT * FROM accounts WHERE id IN (SELECT account_id FROM transfers)",
+	"SELECT * FROM accounts WHERE id = 1 FOR UPDATE",
+	"SELECT * FROM accounts WHERE id = 1 FOR NO KEY UPDATE",
+	"SELECT * FROM accounts WHERE id = 1 FOR SHARE",
+	"SELECT * FROM accounts WHERE id = 1 FOR KEY SHARE",
+	"SELECT * FROM accounts a JOIN transfers t ON t.account_id = a.id FOR UPDATE OF a",
+	"SELECT * FROM accounts WHERE id IN (SELECT account_id FROM transfers) FOR UPDATE SKIP LOCKED",
+	"INSERT INTO transfers (account_id, amount) VALUES (1, 10)",
+	"INSERT INTO transfers (account_id, amount) SELECT id, 1 FROM accounts",
+	"INSERT INTO accounts VALUES (1, 0) ON CONFLICT (id) DO UPDATE SET balance = EXCLUDED.balance",
+	"UPDATE accounts SET balance = balance + 1 WHERE id = 1",
+	"UPDATE accounts SET balance = 0 FROM transfers WHERE transfers.account_id = accounts.id",
+	"DELETE FROM transfers WHERE account_id = 1",
+	"DELETE FROM transfers USING accounts WHERE accounts.id = transfers.account_id",
+	"WITH moved AS (DELETE FROM transfers RETURNING account_id) UPDATE accounts SET balance = 0 WHERE id IN (SELECT account_id FROM moved)",
+	"TRUNCATE transfers",
+	"LOCK TABLE accounts IN SHARE ROW EXCLUSIVE MODE",
+	"LOCK accounts",
+	"CREATE INDEX ON transfers (account_id)",
+	"CREATE INDEX CONCURRENTLY ON transfers (amount)",
+	"REINDEX TABLE transfers",
+	"VACUUM transfers",
+	"ANALYZE transfers",
+	"CLUSTER accounts USING accounts_pkey",
+	"CREATE TRIGGER noop BEFORE INSERT ON transfers FOR EACH ROW EXECUTE PROCEDURE noop()",
+	"CREATE TABLE audits (id INT REFERENCES accounts (id))",
+	"CREATE TABLE accounts_copy AS SELECT * FROM accounts",
+	"ALTER TABLE transfers ADD COLUMN note TEXT",
+	"ALTER TABLE transfers ALTER COLUMN amount TYPE BIGINT",
+	"ALTER TABLE transfers ADD CONSTRAINT transfers_account_fkey FOREIGN KEY (account_id) REFERENCES accounts (id)",
+	"ALTER TABLE transfers ADD CONSTRAINT amount_small CHECK (amount < 1000) NOT VALID",
+	"ALTER TABLE transfers VALIDATE CONSTRAINT amount_positive",
+	"ALTER TABLE transfers ALTER COLUMN amount SET STATISTICS 500",
+	"ALTER TABLE accounts CLUSTER ON accounts_pkey",
+	"ALTER TABLE transfers DISABLE TRIGGER ALL",
+	"DROP TABLE transfers",
+}
@@ -0,0 +1,315 @@
+package lockanalysis
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/13rac1/pg-deadlocks/internal/sqlscan"
+	"github.com/13rac1/pg-deadlocks/lockmon"
+)
+
+// Lint rules.
+const (
+	// RuleDMLWithDDL flags transactions which take an AccessExclusiveLock and
+	// also modify or lock rows. The row locks and RowExclusiveLocks taken
+	// before the DDL are held while it waits, the deadlock of the alter-table
+	// scenario.
+	RuleDMLWithDDL = "dml-with-ddl"
+	// RuleLockOrder flags transactions which take strong locks on two tables
+	// in the opposite order of an earlier migration.
+	RuleLockOrder = "lock-order"
+	// RuleLockTimeout flags statements which block writes to a table while
+	// no lock_timeout is set, queueing every later query behind them.
+	RuleLockTimeout = "lock-timeout"
+)
+
+// Migration is a SQL migration file.
+type Migration struct {
+	Name       string
+	Statements []string
+	// Lines are the lines the statements start on.
+	Lines []int
+}
+
+// Finding is a problem found in a migration.
+type Finding struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s", f.File, f.Line, f.Rule, f.Message)
+}
+
+// ReadMigrations reads the .sql files of dir in the order of their names,
+// which is the order migration tools apply them. Down migrations, named
+// *.down.sql, are skipped.
+func ReadMigrations(dir string) ([]Migration, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	var migrations []Migration
+	for _, name := range names {
+		if strings.HasSuffix(name, ".down.sql") {
+			continue
+		}
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, ParseMigration(name, string(b)))
+	}
+	return migrations, nil
+}
+
+// ParseMigration splits a migration into its statements.
+func ParseMigration(name, script string) Migration {
+	m := Migration{Name: name}
+	offset := 0
+	for _, s := range sqlscan.SplitStatements(script) {
+		i := strings.Index(script[offset:], s)
+		if i < 0 {
+			i = 0
+		}
+		offset += i
+		m.Statements = append(m.Statements, s)
+		m.Lines = append(m.Lines, strings.Count(script[:offset], "\n")+1+leadingCommentLines(s))
+		offset += len(s)
+	}
+	return m
+}
+
+// leadingCommentLines counts the lines of comments kept before a statement.
+func leadingCommentLines(statement string) int {
+	i := 0
+	for i < len(statement) {
+		if end, ok := sqlscan.SkipComment(statement, i); ok {
+			i = end + 1
+			continue
+		}
+		if c := statement[i]; c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			break
+		}
+		i++
+	}
+	if i > len(statement) {
+		i = len(statement)
+	}
+	return strings.Count(statement[:i], "\n")
+}
+
+// LintOptions configure Lint.
+type LintOptions struct {
+	// Version is the server_version_num locks are predicted for.
+	Version int
+	// FileTransaction is true when the migration tool runs every file in a
+	// transaction, otherwise statements outside of BEGIN and COMMIT run in
+	// their own transaction.
+	FileTransaction bool
+}
+
+// lintStatement is an analyzed statement of a migration.
+type lintStatement struct {
+	Statement
+	file string
+	line int
+	// index is the position of the statement in its migration, statements
+	// on the same line are different statements.
+	index int
+}
+
+// setLockTimeout returns the value of a SET [LOCAL | SESSION] lock_timeout
+// statement and whether it is SET LOCAL.
+func setLockTimeout(p *parser) (value string, local, ok bool) {
+	if !p.accept("SET") {
+		return "", false, false
+	}
+	local = p.accept("LOCAL")
+	if !local {
+		p.accept("SESSION")
+	}
+	if !p.accept("LOCK_TIMEOUT") || !p.accept("=") && !p.accept("TO") || p.done() {
+		return "", false, false
+	}
+	t := p.tokens[p.pos]
+	if t.text == "'" {
+		return t.value, local, true
+	}
+	return t.text, local, true
+}
+
+// Lint checks migrations, in the order they are applied, for transactions
+// which are prone to deadlocks or to blocking the queries of the application.
+func Lint(migrations []Migration, opts LintOptions) []Finding {
+	var findings []Finding
+	// order has the first location taking strong locks on a pair of tables
+	// in order.
+	order := map[[2]string]lintStatement{}
+	for _, m := range migrations {
+		// created are the tables created by the migration, nobody else
+		// waits for their locks.
+		created := map[string]bool{}
+		var (
+			tx      []lintStatement
+			inBlock bool
+			timeout bool
+			// localTimeout is set by SET LOCAL for the transaction, it
+			// overrides timeout when not nil.
+			localTimeout *bool
+		)
+		end := func() {
+			findings = append(findings, lintTransaction(tx, created, order)...)
+			tx = nil
+			localTimeout = nil
+		}
+		for i, query := range m.Statements {
+			s := lintStatement{Analyze(query, opts.Version), m.Name, m.Lines[i], i}
+			p := newParser(query)
+			switch {
+			case p.peek("BEGIN"), p.peek("START", "TRANSACTION"):
+				if !opts.FileTransaction {
+					end()
+				}
+				inBlock = true
+				continue
+			case p.peek("COMMIT"), p.peek("END"), p.peek("ROLLBACK") && !p.peek("ROLLBACK", "TO"):
+				inBlock = false
+				if !opts.FileTransaction {
+					end()
+				}
+				continue
+			}
+			if value, local, ok := setLockTimeout(p); ok {
+				set := !disabledTimeout(value)
+				if local {
+					localTimeout = &set
+				} else {
+					timeout = set
+				}
+			}
+			if p.peek("CREATE") && len(s.Tables) > 0 && s.Tables[0].Mode == "AccessExclusiveLock" {
+				created[s.Tables[0].Table] = true
+			}
+			tx = append(tx, s)
+			hasTimeout := timeout
+			if localTimeout != nil {
+				hasTimeout = *localTimeout
+			}
+			if mode, table := blockingLock(s.Statement, created); mode != "" && !hasTimeout {
+				findings = append(findings, Finding{s.file, s.line, RuleLockTimeout,
+					fmt.Sprintf("%s on %s blocks writes while waiting, without a lock_timeout every query on %s queues behind it", mode, table, table)})
+			}
+			if !inBlock && !opts.FileTransaction {
+				end()
+			}
+		}
+		end()
+	}
+	return findings
+}
+
+// disabledTimeout reports whether a lock_timeout setting, e.g. '50ms' or 0,
+// disables the timeout.
+func disabledTimeout(value string) bool {
+	value = strings.ToLower(strings.TrimSpace(value))
+	return value == "default" || strings.Trim(value, "0 msinhd") == ""
+}
+
+// blockingLock returns the first lock of s which conflicts with the
+// RowExclusiveLock of INSERT, UPDATE and DELETE on a table not created by the
+// migration.
+func blockingLock(s Statement, created map[string]bool) (string, string) {
+	for _, l := range s.Tables {
+		if lockmon.Conflicts(l.Mode, "RowExclusiveLock") && !created[l.Table] {
+			return l.Mode, l.Table
+		}
+	}
+	return "", ""
+}
+
+// lintTransaction checks the statements of one transaction, and records the
+// order it takes strong locks in.
+func lintTransaction(tx []lintStatement, created map[string]bool, order map[[2]string]lintStatement) []Finding {
+	var findings []Finding
+	var dml []lintStatement
+	for _, s := range tx {
+		if modifiesRows(s.Statement) {
+			dml = append(dml, s)
+		}
+	}
+	for _, s := range tx {
+		table := exclusiveTable(s.Statement, created)
+		if table == "" {
+			continue
+		}
+		for _, d := range dml {
+			if d.index == s.index {
+				continue
+			}
+			when := "after"
+			if d.index > s.index {
+				when = "before"
+			}
+			findings = append(findings, Finding{s.file, s.line, RuleDMLWithDDL,
+				fmt.Sprintf("AccessExclusiveLock on %s in the same transaction as, and %s, the DML at line %d, run them in separate transactions",
+					table, when, d.line)})
+			break
+		}
+	}
+
+	type strongLock struct {
+		table string
+		at    lintStatement
+	}
+	var strong []strongLock
+	seen := map[string]bool{}
+	for _, s := range tx {
+		for _, l := range s.Tables {
+			if lockmon.Conflicts(l.Mode, "RowExclusiveLock") && !created[l.Table] && !seen[l.Table] {
+				seen[l.Table] = true
+				strong = append(strong, strongLock{l.Table, s})
+			}
+		}
+	}
+	for i, a := range strong {
+		for _, b := range strong[i+1:] {
+			if first, ok := order[[2]string{b.table, a.table}]; ok {
+				findings = append(findings, Finding{b.at.file, b.at.line, RuleLockOrder,
+					fmt.Sprintf("locks %s after %s, but %s:%d locks %s before %s", b.table, a.table, first.file, first.line, b.table, a.table)})
+			}
+			if _, ok := order[[2]string{a.table, b.table}]; !ok {
+				order[[2]string{a.table, b.table}] = b.at
+			}
+		}
+	}
+	return findings
+}
+
+// modifiesRows reports whether s is DML, it takes RowExclusiveLock or row
+// locks.
+func modifiesRows(s Statement) bool {
+	for _, l := range s.Tables {
+		if l.Mode == "RowExclusiveLock" {
+			return true
+		}
+	}
+	return len(s.Rows) > 0
+}
+
+// exclusiveTable returns the first table not created by the migration s takes
+// an AccessExclusiveLock on.
+func exclusiveTable(s Statement, created map[string]bool) string {
+	for _, l := range s.Tables {
+		if l.Mode == "AccessExclusiveLock" && !created[l.Table] {
+			return l.Table
+		}
+	}
+	return ""
+}
@@ -0,0 +1,180 @@
+package lockanalysis
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMigration(t *testing.T) {
+	script := `-- create the table
+CREATE TABLE a (id INT);
+
+/* the index */
+CREATE INDEX ON a (id); INSERT INTO a VALUES (1);
+UPDATE a
+SET id = 2;
+SELECT ';'`
+	m := ParseMigration("1.sql", script)
+	if want := []int{2, 5, 5, 6, 8}; !reflect.DeepEqual(m.Lines, want) {
+		t.Errorf("lines %v, want %v", m.Lines, want)
+	}
+	if len(m.Statements) != 5 || m.Statements[4] != "SELECT ';'" {
+		t.Errorf("statements %q", m.Statements)
+	}
+}
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name       string
+		migrations []string
+		opts       LintOptions
+		want       []string
+	}{
+		{
+			name:       "alter with timeout",
+			migrations: []string{"SET lock_timeout = '1s';\nALTER TABLE users ADD COLUMN c INT;"},
+		},
+		{
+			name:       "alter without timeout",
+			migrations: []string{"ALTER TABLE users ADD COLUMN c INT;"},
+			want:       []string{"1.sql:1: lock-timeout: AccessExclusiveLock on users"},
+		},
+		{
+			name:       "weak lock needs no timeout",
+			migrations: []string{"CREATE INDEX CONCURRENTLY ON users (c);\nALTER TABLE users VALIDATE CONSTRAINT c;"},
+		},
+		{
+			name:       "created table needs no timeout",
+			migrations: []string{"CREATE TABLE t (id INT);\nALTER TABLE t ADD COLUMN c INT;\nUPDATE t SET c = 1;"},
+		},
+		{
+			name:       "timeout disabled",
+			migrations: []string{"SET lock_timeout TO 0;\nCREATE INDEX ON users (c);\nSET lock_timeout = '5s';\nSET lock_timeout = DEFAULT;\nLOCK users;"},
+			want: []string{
+				"1.sql:2: lock-timeout: ShareLock on users",
+				"1.sql:5: lock-timeout: AccessExclusiveLock on users",
+			},
+		},
+		{
+			name:       "timeout with comments",
+			migrations: []string{"-- no waiting\nSET /* ms */ lock_timeout = '50ms'; -- fail fast\nALTER TABLE users ADD COLUMN c INT;"},
+		},
+		{
+			name:       "timeout of earlier migration",
+			migrations: []string{"SET lock_timeout = '1s';", "ALTER TABLE users ADD COLUMN c INT;"},
+			want:       []string{"2.sql:1: lock-timeout: AccessExclusiveLock on users"},
+		},
+		{
+			name: "set local lasts for the transaction",
+			migrations: []string{`BEGIN;
+SET LOCAL lock_timeout = '1s';
+ALTER TABLE users ADD COLUMN c INT;
+COMMIT;
+ALTER TABLE users ADD COLUMN d INT;`},
+			want: []string{"1.sql:5: lock-timeout: AccessExclusiveLock on users"},
+		},
+		{
+			name: "set local overrides the session",
+			migrations: []string{`SET lock_timeout = '1s';
+BEGIN;
+SET LOCAL lock_timeout = 0;
+ALTER TABLE users ADD COLUMN c INT;
+COMMIT;
+ALTER TABLE users ADD COLUMN d INT;`},
+			want: []string{"1.sql:4: lock-timeout: AccessExclusiveLock on users"},
+		},
+		{
+			name: "dml before ddl",
+			migrations: []string{`SET lock_timeout = '1s';
+BEGIN;
+UPDATE users SET c = 1;
+ALTER TABLE users ADD COLUMN c INT;
+COMMIT;`},
+			want: []string{"1.sql:4: dml-with-ddl: AccessExclusiveLock on users in the same transaction as, and after, the DML at line 3"},
+		},
+		{
+			name: "dml after ddl",
+			migrations: []string{`SET lock_timeout = '1s';
+BEGIN;
+ALTER TABLE users ADD COLUMN c INT;
+SELECT * FROM orders FOR UPDATE;
+COMMIT;`},
+			want: []string{"1.sql:3: dml-with-ddl: AccessExclusiveLock on users in the same transaction as, and before, the DML at line 4"},
+		},
+		{
+			name:       "dml and ddl on one line",
+			migrations: []string{"SET lock_timeout = '1s';\nBEGIN; UPDATE users SET c = 1; ALTER TABLE users ADD COLUMN c INT; COMMIT;"},
+			want:       []string{"1.sql:2: dml-with-ddl: AccessExclusiveLock on users in the same transaction as, and after, the DML at line 2"},
+		},
+		{
+			name:       "dml and ddl in separate transactions",
+			migrations: []string{"SET lock_timeout = '1s';\nUPDATE users SET c = 1;\nALTER TABLE users ADD COLUMN c INT;"},
+		},
+		{
+			name:       "dml and ddl in a file transaction",
+			migrations: []string{"SET lock_timeout = '1s';\nUPDATE users SET c = 1;\nALTER TABLE users ADD COLUMN c INT;"},
+			opts:       LintOptions{FileTransaction: true},
+			want:       []string{"1.sql:3: dml-with-ddl: AccessExclusiveLock on users in the same transaction as, and after, the DML at line 2"},
+		},
+		{
+			name:       "file transactions end with the file",
+			migrations: []string{"SET lock_timeout = '1s';\nUPDATE users SET c = 1;", "SET lock_timeout = '1s';\nALTER TABLE users ADD COLUMN c INT;"},
+			opts:       LintOptions{FileTransaction: true},
+		},
+		{
+			name: "ddl on a created table",
+			migrations: []string{`BEGIN;
+CREATE TABLE t (id INT);
+INSERT INTO t VALUES (1);
+ALTER TABLE t ADD COLUMN c INT;
+COMMIT;`},
+		},
+		{
+			name: "lock order",
+			migrations: []string{
+				"SET lock_timeout = '1s';\nBEGIN;\nLOCK a;\nLOCK b;\nCOMMIT;",
+				"SET lock_timeout = '1s';\nBEGIN;\nLOCK TABLE b IN SHARE MODE;\nLOCK a IN EXCLUSIVE MODE;\nCOMMIT;",
+			},
+			want: []string{"2.sql:4: lock-order: locks a after b, but 1.sql:4 locks a before b"},
+		},
+		{
+			name: "same lock order",
+			migrations: []string{
+				"SET lock_timeout = '1s';\nBEGIN;\nLOCK a;\nLOCK b;\nCOMMIT;",
+				"SET lock_timeout = '1s';\nBEGIN;\nLOCK a;\nLOCK b;\nCOMMIT;",
+			},
+		},
+		{
+			name: "lock order of separate transactions",
+			migrations: []string{
+				"SET lock_timeout = '1s';\nLOCK a;\nLOCK b;",
+				"SET lock_timeout = '1s';\nLOCK b;\nLOCK a;",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var migrations []Migration
+			for i, script := range tt.migrations {
+				migrations = append(migrations, ParseMigration(fmt.Sprintf("%d.sql", i+1), script))
+			}
+			if tt.opts.Version == 0 {
+				tt.opts.Version = 140000
+			}
+			var got []string
+			for _, f := range Lint(migrations, tt.opts) {
+				got = append(got, f.String())
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("findings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+			for i := range got {
+				if !strings.HasPrefix(got[i], tt.want[i]) {
+					t.Errorf("finding %s, want %s", got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
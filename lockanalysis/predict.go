@@ -0,0 +1,158 @@
+package lockanalysis
+
+import (
+	"fmt"
+
+	"github.com/13rac1/pg-deadlocks/lockmon"
+)
+
+// rowConflicts lists for every row lock strength the strengths it conflicts
+// with, see
+// https://www.postgresql.org/docs/current/explicit-locking.html#ROW-LOCK-COMPATIBILITY
+var rowConflicts = map[string][]string{
+	ForKeyShare:    {ForUpdate},
+	ForShare:       {ForNoKeyUpdate, ForUpdate},
+	ForNoKeyUpdate: {ForShare, ForNoKeyUpdate, ForUpdate},
+	ForUpdate:      {ForKeyShare, ForShare, ForNoKeyUpdate, ForUpdate},
+}
+
+// Acquisition is a lock taken by a statement of a script.
+type Acquisition struct {
+	// Statement is the index of the statement in the script.
+	Statement int
+	// Tx numbers the transactions of the script, locks are held until the
+	// end of their transaction.
+	Tx    int
+	Table string
+	// Mode is a table lock mode, or a row lock strength when Row is set.
+	Mode string
+	Row  bool
+}
+
+func (a Acquisition) String() string {
+	if a.Row {
+		return fmt.Sprintf("%s on rows of %s", a.Mode, a.Table)
+	}
+	return fmt.Sprintf("%s on %s", a.Mode, a.Table)
+}
+
+// conflicts reports whether two acquisitions of different sessions block each
+// other. Row locks conflict when they are on the same rows, which is not
+// known statically.
+func (a Acquisition) conflicts(b Acquisition) bool {
+	if a.Table != b.Table || a.Row != b.Row {
+		return false
+	}
+	if a.Row {
+		for _, s := range rowConflicts[a.Mode] {
+			if s == b.Mode {
+				return true
+			}
+		}
+		return false
+	}
+	return lockmon.Conflicts(a.Mode, b.Mode)
+}
+
+// TransactionScript returns m with its statements wrapped in BEGIN and
+// COMMIT when it has no BEGIN of its own, a file of statements is then one
+// transaction. The added statements are on line 0.
+func TransactionScript(m Migration) Migration {
+	for _, query := range m.Statements {
+		p := newParser(query)
+		if p.peek("BEGIN") || p.peek("START", "TRANSACTION") {
+			return m
+		}
+	}
+	return Migration{
+		Name:       m.Name,
+		Statements: append(append([]string{"BEGIN"}, m.Statements...), "COMMIT"),
+		Lines:      append(append([]int{0}, m.Lines...), 0),
+	}
+}
+
+// Acquisitions returns the locks taken by the statements of a script in the
+// order they are taken. Statements outside of BEGIN and COMMIT are their own
+// transaction.
+func Acquisitions(statements []string, version int) []Acquisition {
+	var locks []Acquisition
+	tx, inBlock := 0, false
+	for i, query := range statements {
+		p := newParser(query)
+		switch {
+		case p.peek("BEGIN"), p.peek("START", "TRANSACTION"):
+			tx++
+			inBlock = true
+			continue
+		case p.peek("COMMIT"), p.peek("END"), p.peek("ROLLBACK") && !p.peek("ROLLBACK", "TO"):
+			inBlock = false
+			continue
+		}
+		if !inBlock {
+			tx++
+		}
+		s := Analyze(query, version)
+		for _, l := range s.Tables {
+			locks = append(locks, Acquisition{Statement: i, Tx: tx, Table: l.Table, Mode: l.Mode})
+		}
+		for _, l := range s.Rows {
+			locks = append(locks, Acquisition{Statement: i, Tx: tx, Table: l.Table, Mode: l.Strength, Row: true})
+		}
+	}
+	return locks
+}
+
+// Inversion is a lock order inversion between two scripts: A holds HeldA
+// when it requests WantA, which conflicts with HeldB, while B holds HeldB
+// and requests WantB, which conflicts with HeldA. Run concurrently the
+// scripts can deadlock.
+type Inversion struct {
+	HeldA, WantA, HeldB, WantB Acquisition
+	// Rows is set when the inversion involves row locks, it only deadlocks
+	// when both scripts lock the same rows.
+	Rows bool
+}
+
+// PredictDeadlocks returns the lock order inversions between the
+// transactions of two scripts.
+func PredictDeadlocks(a, b []string, version int) []Inversion {
+	locksA, locksB := Acquisitions(a, version), Acquisitions(b, version)
+	var found []Inversion
+	seen := map[string]bool{}
+	for _, inv := range holdAndWait(locksA, locksB) {
+		key := fmt.Sprintf("%d %d %d %d %s %s", inv.HeldA.Statement, inv.WantA.Statement,
+			inv.HeldB.Statement, inv.WantB.Statement, inv.WantA.Table, inv.WantB.Table)
+		if !seen[key] {
+			seen[key] = true
+			found = append(found, inv)
+		}
+	}
+	return found
+}
+
+// holdAndWait pairs every lock held by A while requesting a later lock with
+// the locks of B which close a cycle. Conflicting table locks cannot be held
+// by A and B at the same time, one of them waits for the other before the
+// cycle forms. Row locks are the exception, they may be on different rows.
+func holdAndWait(locksA, locksB []Acquisition) []Inversion {
+	var found []Inversion
+	for i, heldA := range locksA {
+		for _, wantA := range locksA[i+1:] {
+			if wantA.Tx != heldA.Tx || wantA.Statement == heldA.Statement {
+				continue
+			}
+			for j, heldB := range locksB {
+				if !wantA.conflicts(heldB) || !heldA.Row && !heldB.Row && heldA.conflicts(heldB) {
+					continue
+				}
+				for _, wantB := range locksB[j+1:] {
+					if wantB.Tx != heldB.Tx || wantB.Statement == heldB.Statement || !wantB.conflicts(heldA) {
+						continue
+					}
+					found = append(found, Inversion{heldA, wantA, heldB, wantB, heldA.Row || wantA.Row})
+				}
+			}
+		}
+	}
+	return found
+}
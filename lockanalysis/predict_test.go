@@ -0,0 +1,64 @@
+package lockanalysis
+
+import "testing"
+
+func TestPredictDeadlocks(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want []string
+	}{
+		{
+			name: "update x and y in opposite orders",
+			a:    []string{"BEGIN", "UPDATE x SET v = 1 WHERE id = 1", "UPDATE y SET v = 1 WHERE id = 1", "COMMIT"},
+			b:    []string{"BEGIN", "UPDATE y SET v = 2 WHERE id = 1", "UPDATE x SET v = 2 WHERE id = 1", "COMMIT"},
+			want: []string{"FOR NO KEY UPDATE on rows of x, FOR NO KEY UPDATE on rows of y, rows"},
+		},
+		{
+			name: "update x and y in the same order",
+			a:    []string{"BEGIN", "UPDATE x SET v = 1 WHERE id = 1", "UPDATE y SET v = 1 WHERE id = 1", "COMMIT"},
+			b:    []string{"BEGIN", "UPDATE x SET v = 2 WHERE id = 1", "UPDATE y SET v = 2 WHERE id = 1", "COMMIT"},
+		},
+		{
+			name: "update x and y in separate transactions",
+			a:    []string{"UPDATE x SET v = 1 WHERE id = 1", "UPDATE y SET v = 1 WHERE id = 1"},
+			b:    []string{"UPDATE y SET v = 2 WHERE id = 1", "UPDATE x SET v = 2 WHERE id = 1"},
+		},
+		{
+			// The second LOCK waits for the first COMMIT, the upgrade to
+			// AccessExclusiveLock cannot deadlock.
+			name: "self conflicting lock before alter",
+			a:    []string{"BEGIN", "LOCK TABLE x IN SHARE ROW EXCLUSIVE MODE", "ALTER TABLE x ADD COLUMN c int", "COMMIT"},
+			b:    []string{"BEGIN", "LOCK TABLE x IN SHARE ROW EXCLUSIVE MODE", "ALTER TABLE x ADD COLUMN c int", "COMMIT"},
+		},
+		{
+			name: "lock upgrade",
+			a:    []string{"BEGIN", "SELECT * FROM x", "ALTER TABLE x ADD COLUMN c int", "COMMIT"},
+			b:    []string{"BEGIN", "SELECT * FROM x", "ALTER TABLE x ADD COLUMN c int", "COMMIT"},
+			want: []string{"AccessShareLock on x, AccessExclusiveLock on x"},
+		},
+		{
+			name: "lock tables in opposite orders",
+			a:    []string{"BEGIN", "LOCK x IN SHARE MODE", "LOCK y", "COMMIT"},
+			b:    []string{"BEGIN", "LOCK y IN SHARE MODE", "LOCK x", "COMMIT"},
+			want: []string{"ShareLock on x, AccessExclusiveLock on y"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found := PredictDeadlocks(tt.a, tt.b, 140000)
+			if len(found) != len(tt.want) {
+				t.Fatalf("%d inversions %v, want %d", len(found), found, len(tt.want))
+			}
+			for i, inv := range found {
+				got := inv.HeldA.String() + ", " + inv.WantA.String()
+				if inv.Rows {
+					got += ", rows"
+				}
+				if got != tt.want[i] {
+					t.Errorf("inversion %d holds and wants %s, want %s", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
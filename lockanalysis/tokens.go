@@ -0,0 +1,174 @@
+package lockanalysis
+
+import (
+	"strings"
+
+	"github.com/13rac1/pg-deadlocks/internal/sqlscan"
+)
+
+// token is a word, literal or punctuation character of a statement.
+type token struct {
+	// text is the upper cased word, the punctuation character, or "'" for
+	// string literals and `"` for quoted identifiers, so keywords compare
+	// equal to text.
+	text string
+	// name is the identifier as Postgres folds it, empty for literals and
+	// punctuation.
+	name string
+	// value is the content of a string literal.
+	value string
+}
+
+// tokenize splits a statement into tokens, dropping comments and white space.
+func tokenize(query string) []token {
+	var tokens []token
+	for i := 0; i < len(query); i++ {
+		if end, ok := sqlscan.SkipComment(query, i); ok {
+			i = end
+			continue
+		}
+		c := query[i]
+		if c == '$' {
+			if tag, ok := sqlscan.DollarTag(query[i:]); ok {
+				end := sqlscan.SkipDollarQuoted(query, i, tag)
+				value := query[i+len(tag):]
+				if end < len(query) {
+					value = query[i+len(tag) : end-len(tag)+1]
+				}
+				tokens = append(tokens, token{text: "'", value: value})
+				i = end
+				continue
+			}
+		}
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+		case c == '\'':
+			end := sqlscan.SkipQuoted(query, i, c)
+			value := strings.ReplaceAll(query[i+1:end], "''", "'")
+			tokens = append(tokens, token{text: "'", value: value})
+			i = end
+		case c == '"':
+			end := sqlscan.SkipQuoted(query, i, c)
+			name := strings.ReplaceAll(query[i+1:end], `""`, `"`)
+			tokens = append(tokens, token{text: `"`, name: name})
+			i = end
+		case isWordChar(c):
+			j := i
+			for j < len(query) && (isWordChar(query[j]) || query[j] == '$') {
+				j++
+			}
+			word := query[i:j]
+			t := token{text: strings.ToUpper(word)}
+			if c < '0' || c > '9' {
+				t.name = strings.ToLower(word)
+			}
+			tokens = append(tokens, t)
+			i = j - 1
+		default:
+			tokens = append(tokens, token{text: string(c)})
+		}
+	}
+	return tokens
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// parser is a cursor over the tokens of a statement.
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func newParser(query string) *parser {
+	return &parser{tokens: tokenize(query)}
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+// at reports whether the tokens at i are words.
+func (p *parser) at(i int, words ...string) bool {
+	if i < 0 || i+len(words) > len(p.tokens) {
+		return false
+	}
+	for j, w := range words {
+		if p.tokens[i+j].text != w {
+			return false
+		}
+	}
+	return true
+}
+
+// peek reports whether the next tokens are words.
+func (p *parser) peek(words ...string) bool {
+	return p.at(p.pos, words...)
+}
+
+// accept consumes the next tokens if they are words.
+func (p *parser) accept(words ...string) bool {
+	if !p.peek(words...) {
+		return false
+	}
+	p.pos += len(words)
+	return true
+}
+
+// name consumes a possibly schema qualified name, it returns the name without
+// the schema.
+func (p *parser) name() (string, bool) {
+	if p.done() || p.tokens[p.pos].name == "" {
+		return "", false
+	}
+	name := p.tokens[p.pos].name
+	p.pos++
+	for p.peek(".") && p.pos+1 < len(p.tokens) && p.tokens[p.pos+1].name != "" {
+		name = p.tokens[p.pos+1].name
+		p.pos += 2
+	}
+	return name, true
+}
+
+// skipParens consumes a parenthesized list if one follows.
+func (p *parser) skipParens() {
+	if !p.peek("(") {
+		return
+	}
+	depth := 0
+	for ; !p.done(); p.pos++ {
+		switch p.tokens[p.pos].text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				p.pos++
+				return
+			}
+		}
+	}
+}
+
+// skipTo consumes tokens up to the next of words at the current nesting
+// level, it reports whether one was found.
+func (p *parser) skipTo(words ...string) bool {
+	depth := 0
+	for ; !p.done(); p.pos++ {
+		t := p.tokens[p.pos].text
+		switch {
+		case t == "(":
+			depth++
+		case t == ")":
+			depth--
+		case depth == 0:
+			for _, w := range words {
+				if t == w {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
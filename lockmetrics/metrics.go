@@ -0,0 +1,286 @@
+// Package lockmetrics exposes lock waits and deadlocks of a Postgres server
+// as Prometheus metrics, in the text exposition format.
+package lockmetrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/13rac1/pg-deadlocks/lockmon"
+)
+
+// LockWaitBuckets are the upper bounds of the lock wait duration histogram,
+// in seconds.
+var LockWaitBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// waitKey labels the waiting sessions gauge.
+type waitKey struct {
+	scenario, eventType, event string
+}
+
+// histogram is a Prometheus histogram, counts are per bucket, not cumulative.
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(LockWaitBuckets))
+	}
+	for i, bound := range LockWaitBuckets {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// waitStart is a lock wait seen by the sampler.
+type waitStart struct {
+	scenario string
+	start    time.Time
+}
+
+// Collector samples the sessions of a server and serves the metrics. The
+// zero value is not usable, see NewCollector.
+type Collector struct {
+	mu       sync.Mutex
+	db       *sqlx.DB
+	version  int
+	scenario string
+	waiting  map[waitKey]int
+	// waits are the lock waits in progress by pid.
+	waits        map[int]waitStart
+	lockWaits    map[string]*histogram
+	logDeadlocks map[string]uint64
+	// logAttached is set once a server log is parsed, without one the log
+	// deadlocks counter is not served rather than always 0.
+	logAttached bool
+}
+
+// NewCollector returns a collector without a server, deadlocks can be
+// counted before Watch starts sampling.
+func NewCollector() *Collector {
+	return &Collector{
+		waiting:      map[waitKey]int{},
+		waits:        map[int]waitStart{},
+		lockWaits:    map[string]*histogram{},
+		logDeadlocks: map[string]uint64{},
+	}
+}
+
+// SetScenario sets the scenario label of the metrics observed from now on.
+func (c *Collector) SetScenario(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scenario = name
+}
+
+// LogAttached records that the server log is parsed and deadlocks found in
+// it are counted with LogDeadlock. pg_deadlocks_log_deadlocks_total is only
+// served after it was called.
+func (c *Collector) LogAttached() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logAttached = true
+}
+
+// LogDeadlock counts a deadlock parsed from the server log.
+func (c *Collector) LogDeadlock() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logDeadlocks[c.scenario]++
+}
+
+// Watch samples the sessions of db every interval until ctx is done. Lock
+// waits are timed from the first sample they are seen in to the first one
+// they are gone from, so interval is the resolution of the histogram.
+// failed, unless nil, is called with the error of every failed sample,
+// sampling continues with the next one.
+func (c *Collector) Watch(ctx context.Context, db *sqlx.DB, interval time.Duration, failed func(error)) error {
+	version, err := lockmon.ServerVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.db, c.version = db, version
+	c.mu.Unlock()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				sessions, err := lockmon.SampleSessions(ctx, db, version)
+				if err != nil {
+					if ctx.Err() == nil && failed != nil {
+						failed(err)
+					}
+					continue
+				}
+				c.sample(sessions, time.Now())
+			}
+		}
+	}()
+	return nil
+}
+
+// sample updates the waiting sessions gauge and times the lock waits.
+func (c *Collector) sample(sessions []lockmon.Session, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.waiting = map[waitKey]int{}
+	lockWaiting := map[int]bool{}
+	for _, s := range sessions {
+		if s.State != "active" || s.WaitEventType == "" {
+			continue
+		}
+		c.waiting[waitKey{c.scenario, s.WaitEventType, s.WaitEvent}]++
+		if s.WaitEventType != "Lock" {
+			continue
+		}
+		lockWaiting[s.PID] = true
+		// A query of the same backend started since the wait was first seen
+		// is a new wait.
+		if w, ok := c.waits[s.PID]; !ok || now.Add(-s.QueryAge()).After(w.start) {
+			if ok {
+				c.observeWait(w, now)
+			}
+			c.waits[s.PID] = waitStart{c.scenario, now}
+		}
+	}
+	for pid, w := range c.waits {
+		if !lockWaiting[pid] {
+			c.observeWait(w, now)
+			delete(c.waits, pid)
+		}
+	}
+}
+
+func (c *Collector) observeWait(w waitStart, now time.Time) {
+	h, ok := c.lockWaits[w.scenario]
+	if !ok {
+		h = &histogram{}
+		c.lockWaits[w.scenario] = h
+	}
+	h.observe(now.Sub(w.start).Seconds())
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var databases []struct {
+		Name      string `db:"datname"`
+		Deadlocks int64
+	}
+	c.mu.Lock()
+	db := c.db
+	c.mu.Unlock()
+	if db != nil {
+		err := db.SelectContext(r.Context(), &databases,
+			"SELECT datname, deadlocks FROM pg_stat_database WHERE datname IS NOT NULL ORDER BY datname")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	version := strconv.Itoa(c.version)
+
+	header(w, "pg_deadlocks_waiting_sessions", "gauge", "Active sessions waiting, by wait event.")
+	var keys []waitKey
+	for k := range c.waiting {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.scenario != b.scenario {
+			return a.scenario < b.scenario
+		}
+		if a.eventType != b.eventType {
+			return a.eventType < b.eventType
+		}
+		return a.event < b.event
+	})
+	for _, k := range keys {
+		sample(w, "pg_deadlocks_waiting_sessions", labels("scenario", k.scenario, "server_version", version,
+			"wait_event_type", k.eventType, "wait_event", k.event), float64(c.waiting[k]))
+	}
+
+	header(w, "pg_deadlocks_lock_wait_seconds", "histogram", "Duration of lock waits, sampled from pg_stat_activity.")
+	var scenarios []string
+	for scenario := range c.lockWaits {
+		scenarios = append(scenarios, scenario)
+	}
+	sort.Strings(scenarios)
+	for _, scenario := range scenarios {
+		h := c.lockWaits[scenario]
+		var cumulative uint64
+		for i, bound := range LockWaitBuckets {
+			cumulative += h.counts[i]
+			sample(w, "pg_deadlocks_lock_wait_seconds_bucket", labels("scenario", scenario, "server_version", version,
+				"le", strconv.FormatFloat(bound, 'g', -1, 64)), float64(cumulative))
+		}
+		l := labels("scenario", scenario, "server_version", version)
+		sample(w, "pg_deadlocks_lock_wait_seconds_bucket", labels("scenario", scenario, "server_version", version, "le", "+Inf"), float64(h.count))
+		sample(w, "pg_deadlocks_lock_wait_seconds_sum", l, h.sum)
+		sample(w, "pg_deadlocks_lock_wait_seconds_count", l, float64(h.count))
+	}
+
+	if c.logAttached {
+		header(w, "pg_deadlocks_log_deadlocks_total", "counter", "Deadlocks parsed from the server log.")
+		scenarios = nil
+		for scenario := range c.logDeadlocks {
+			scenarios = append(scenarios, scenario)
+		}
+		sort.Strings(scenarios)
+		for _, scenario := range scenarios {
+			sample(w, "pg_deadlocks_log_deadlocks_total", labels("scenario", scenario, "server_version", version),
+				float64(c.logDeadlocks[scenario]))
+		}
+	}
+
+	// The counters of pg_stat_database span scenarios, they are not
+	// labelled with one.
+	header(w, "pg_deadlocks_database_deadlocks_total", "counter", "Deadlocks detected in each database, from pg_stat_database.")
+	for _, d := range databases {
+		sample(w, "pg_deadlocks_database_deadlocks_total", labels("server_version", version, "datname", d.Name),
+			float64(d.Deadlocks))
+	}
+}
+
+func header(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func sample(w io.Writer, name, labels string, value float64) {
+	fmt.Fprintf(w, "%s{%s} %s\n", name, labels, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats name and value pairs as a label set.
+func labels(pairs ...string) string {
+	var l []string
+	for i := 0; i < len(pairs); i += 2 {
+		l = append(l, fmt.Sprintf(`%s="%s"`, pairs[i], labelEscaper.Replace(pairs[i+1])))
+	}
+	return strings.Join(l, ",")
+}
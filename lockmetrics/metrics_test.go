@@ -0,0 +1,121 @@
+package lockmetrics
+
+import (
+	"math"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/13rac1/pg-deadlocks/lockmon"
+)
+
+func TestSampleTimesLockWaits(t *testing.T) {
+	c := NewCollector()
+	c.SetScenario("a")
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time {
+		return t0.Add(time.Duration(ms) * time.Millisecond)
+	}
+	lockWait := func(pid int, querySeconds float64) lockmon.Session {
+		return lockmon.Session{PID: pid, State: "active", WaitEventType: "Lock", WaitEvent: "transactionid", QuerySeconds: querySeconds}
+	}
+	idle := lockmon.Session{PID: 9, State: "idle in transaction", WaitEventType: "Client", WaitEvent: "ClientRead"}
+	io := lockmon.Session{PID: 8, State: "active", WaitEventType: "IO", WaitEvent: "DataFileRead"}
+
+	c.sample([]lockmon.Session{lockWait(1, 0), idle, io}, at(0))
+	want := map[waitKey]int{{"a", "Lock", "transactionid"}: 1, {"a", "IO", "DataFileRead"}: 1}
+	if !reflect.DeepEqual(c.waiting, want) {
+		t.Errorf("waiting %v, want %v", c.waiting, want)
+	}
+	// The same query still waits.
+	c.sample([]lockmon.Session{lockWait(1, 0.1)}, at(100))
+	// The wait of pid 1 ended, pid 2 starts waiting in scenario b.
+	c.SetScenario("b")
+	c.sample([]lockmon.Session{lockWait(2, 0)}, at(300))
+	// pid 2 runs a new query, which waits again.
+	c.sample([]lockmon.Session{lockWait(2, 0.05)}, at(2300))
+	c.sample(nil, at(2400))
+	if len(c.waiting) != 0 || len(c.waits) != 0 {
+		t.Errorf("waiting %v and waits %v without sessions", c.waiting, c.waits)
+	}
+
+	tests := []struct {
+		scenario string
+		counts   []uint64
+		count    uint64
+		sum      float64
+	}{
+		{"a", []uint64{0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0}, 1, 0.3},
+		{"b", []uint64{0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 0}, 2, 2.1},
+	}
+	for _, tt := range tests {
+		h := c.lockWaits[tt.scenario]
+		if h == nil {
+			t.Errorf("no lock waits of scenario %s", tt.scenario)
+			continue
+		}
+		if !reflect.DeepEqual(h.counts, tt.counts) {
+			t.Errorf("scenario %s bucket counts %v, want %v", tt.scenario, h.counts, tt.counts)
+		}
+		if h.count != tt.count || math.Abs(h.sum-tt.sum) > 1e-9 {
+			t.Errorf("scenario %s count %d sum %g, want %d and %g", tt.scenario, h.count, h.sum, tt.count, tt.sum)
+		}
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	c := NewCollector()
+	c.version = 140000
+	c.SetScenario(`queue/"for-update"`)
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.sample([]lockmon.Session{{PID: 1, State: "active", WaitEventType: "Lock", WaitEvent: "tuple"}}, t0)
+	c.sample([]lockmon.Session{{PID: 2, State: "active", WaitEventType: "Lock", WaitEvent: "relation"}}, t0.Add(50*time.Millisecond))
+	c.LogAttached()
+	c.LogDeadlock()
+
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4" {
+		t.Errorf("content type %q", ct)
+	}
+	l := `scenario="queue/\"for-update\"",server_version="140000"`
+	want := `# HELP pg_deadlocks_waiting_sessions Active sessions waiting, by wait event.
+# TYPE pg_deadlocks_waiting_sessions gauge
+pg_deadlocks_waiting_sessions{` + l + `,wait_event_type="Lock",wait_event="relation"} 1
+# HELP pg_deadlocks_lock_wait_seconds Duration of lock waits, sampled from pg_stat_activity.
+# TYPE pg_deadlocks_lock_wait_seconds histogram
+pg_deadlocks_lock_wait_seconds_bucket{` + l + `,le="0.01"} 0
+pg_deadlocks_lock_wait_seconds_bucket{` + l + `,le="0.05"} 1
+pg_deadlocks_lock_wait_seconds_bucket{` + l + `,le="0.1"} 1
+pg_deadlocks_lock_wait_seconds_bucket{` + l + `,le="0.25"} 1
+pg_deadlocks_lock_wait_seconds_bucket{` + l + `,le="0.5"} 1
+pg_deadlocks_lock_wait_seconds_bucket{` + l + `,le="1"} 1
+pg_deadlocks_lock_wait_seconds_bucket{` + l + `,le="2.5"} 1
+pg_deadlocks_lock_wait_seconds_bucket{` + l + `,le="5"} 1
+pg_deadlocks_lock_wait_seconds_bucket{` + l + `,le="10"} 1
+pg_deadlocks_lock_wait_seconds_bucket{` + l + `,le="30"} 1
+pg_deadlocks_lock_wait_seconds_bucket{` + l + `,le="60"} 1
+pg_deadlocks_lock_wait_seconds_bucket{` + l + `,le="+Inf"} 1
+pg_deadlocks_lock_wait_seconds_sum{` + l + `} 0.05
+pg_deadlocks_lock_wait_seconds_count{` + l + `} 1
+# HELP pg_deadlocks_log_deadlocks_total Deadlocks parsed from the server log.
+# TYPE pg_deadlocks_log_deadlocks_total counter
+pg_deadlocks_log_deadlocks_total{` + l + `} 1
+# HELP pg_deadlocks_database_deadlocks_total Deadlocks detected in each database, from pg_stat_database.
+# TYPE pg_deadlocks_database_deadlocks_total counter
+`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestServeHTTPWithoutLog(t *testing.T) {
+	c := NewCollector()
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if body := rec.Body.String(); strings.Contains(body, "pg_deadlocks_log_deadlocks_total") {
+		t.Errorf("log deadlocks served without a log:\n%s", body)
+	}
+}
@@ -0,0 +1,75 @@
+package lockmon
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// StatActivity is a row of pg_stat_activity.
+type StatActivity struct {
+	Pid             int
+	UserName        string `db:"usename"`
+	ApplicationName string `db:"application_name"`
+	ClientAddress   string `db:"client_addr"`
+	Waiting         bool
+	State           string
+	Query           string
+}
+
+// StatActivityQuery returns the pg_stat_activity query for the server
+// version, 9.6 replaced the waiting column with wait events.
+func StatActivityQuery(version int) string {
+	if version < 90600 {
+		return "select pid, usename, application_name, client_addr, waiting, state, query from pg_stat_activity;"
+	}
+	// Background processes have no user, client address or state.
+	return `select pid, coalesce(usename, '') as usename, application_name,
+		coalesce(client_addr::text, '') as client_addr,
+		coalesce(wait_event_type = 'Lock', false) as waiting,
+		coalesce(state, '') as state, query from pg_stat_activity;`
+}
+
+// WriteConnectionStats writes pg_stat_activity and the prepared transactions
+// to out every second until stop receives.
+func WriteConnectionStats(ctx context.Context, db *sqlx.DB, out io.Writer, stop chan bool) error {
+	version, err := ServerVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	query := StatActivityQuery(version)
+	for {
+		select {
+		case <-stop:
+			fmt.Fprintln(out, "Stopping status loop")
+			return nil
+		default:
+			var statActivity []StatActivity
+			err := db.SelectContext(ctx, &statActivity, query)
+			if err != nil {
+				return fmt.Errorf("unable to read pg_stat_activity: %w", err)
+			}
+			fmt.Fprintf(out, "\n%d connections\n", len(statActivity))
+			for _, a := range statActivity {
+				waiting := ""
+				if a.Waiting {
+					waiting = " waiting"
+				}
+				fmt.Fprintf(out, "  pid %d %s %s%s: %s\n", a.Pid, a.UserName, a.State, waiting, strings.Join(strings.Fields(a.Query), " "))
+			}
+
+			prepared, err := PreparedXacts(ctx, db)
+			if err != nil {
+				return fmt.Errorf("unable to read prepared transactions: %w", err)
+			}
+			for _, p := range prepared {
+				fmt.Fprintf(out, "prepared transaction %s\n", p)
+			}
+			time.Sleep(1 * time.Second)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package lockmon
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// LockConflicts lists for every lock mode the modes it conflicts with, see
+// https://www.postgresql.org/docs/current/explicit-locking.html
+var LockConflicts = map[string][]string{
+	"AccessShareLock":          {"AccessExclusiveLock"},
+	"RowShareLock":             {"ExclusiveLock", "AccessExclusiveLock"},
+	"RowExclusiveLock":         {"ShareLock", "ShareRowExclusiveLock", "ExclusiveLock", "AccessExclusiveLock"},
+	"ShareUpdateExclusiveLock": {"ShareUpdateExclusiveLock", "ShareLock", "ShareRowExclusiveLock", "ExclusiveLock", "AccessExclusiveLock"},
+	"ShareLock":                {"RowExclusiveLock", "ShareUpdateExclusiveLock", "ShareRowExclusiveLock", "ExclusiveLock", "AccessExclusiveLock"},
+	"ShareRowExclusiveLock":    {"RowExclusiveLock", "ShareUpdateExclusiveLock", "ShareLock", "ShareRowExclusiveLock", "ExclusiveLock", "AccessExclusiveLock"},
+	"ExclusiveLock":            {"RowShareLock", "RowExclusiveLock", "ShareUpdateExclusiveLock", "ShareLock", "ShareRowExclusiveLock", "ExclusiveLock", "AccessExclusiveLock"},
+	"AccessExclusiveLock":      {"AccessShareLock", "RowShareLock", "RowExclusiveLock", "ShareUpdateExclusiveLock", "ShareLock", "ShareRowExclusiveLock", "ExclusiveLock", "AccessExclusiveLock"},
+}
+
+// Conflicts reports whether lock mode a conflicts with lock mode b.
+func Conflicts(a, b string) bool {
+	for _, mode := range LockConflicts[a] {
+		if mode == b {
+			return true
+		}
+	}
+	return false
+}
+
+// lockRow is a row of pg_locks. Tag identifies the locked object, Target
+// describes it.
+type lockRow struct {
+	PID                int
+	LockType           string `db:"locktype"`
+	Mode               string
+	Granted            bool
+	VirtualTransaction string `db:"virtualtransaction"`
+	Tag                string
+	Target             string
+	BackendXID         string `db:"backend_xid"`
+}
+
+// WaitEdge is a session waiting for a lock held by another session. Holder is
+// 0 for locks held by a prepared transaction.
+type WaitEdge struct {
+	Waiter int
+	Holder int
+	Mode   string
+	Target string
+}
+
+// Xacts are the transaction ids of a backend. A backend holds an ExclusiveLock
+// on its virtualxid and on every transactionid it was assigned, the xids
+// besides its top level backend_xid belong to subtransactions.
+type Xacts struct {
+	PID        int
+	VirtualXID string
+	XID        string
+	SubXIDs    []string
+}
+
+// LockGraph is a wait-for graph sampled from pg_locks.
+type LockGraph struct {
+	Xacts []Xacts
+	Edges []WaitEdge
+}
+
+// SampleLockGraph reads pg_locks of the whole cluster and builds the wait-for
+// graph. Relation names are resolved in the database db is connected to.
+func SampleLockGraph(ctx context.Context, db *sqlx.DB) (LockGraph, error) {
+	var rows []lockRow
+	err := db.SelectContext(ctx, &rows, `
+	SELECT coalesce(l.pid, 0) AS pid, l.locktype, l.mode, l.granted, l.virtualtransaction,
+		concat_ws(':', l.locktype, l.database, l.relation, l.page, l.tuple, l.virtualxid,
+			l.transactionid, l.classid, l.objid, l.objsubid) AS tag,
+		`+lockTargetSQL+` AS target,
+		coalesce(a.backend_xid::text, '') AS backend_xid
+	FROM pg_locks l
+	LEFT JOIN pg_class c ON c.oid = l.relation
+	LEFT JOIN pg_stat_activity a ON a.pid = l.pid;`)
+	if err != nil {
+		return LockGraph{}, err
+	}
+	return buildLockGraph(rows), nil
+}
+
+// buildLockGraph adds an edge from every waiting lock to the granted locks it
+// conflicts with.
+func buildLockGraph(rows []lockRow) LockGraph {
+	var g LockGraph
+	byPID := map[int]*Xacts{}
+	for _, r := range rows {
+		if r.PID == 0 || !r.Granted {
+			continue
+		}
+		x, ok := byPID[r.PID]
+		if !ok {
+			x = &Xacts{PID: r.PID, XID: r.BackendXID}
+			byPID[r.PID] = x
+		}
+		switch r.LockType {
+		case "virtualxid":
+			x.VirtualXID = r.VirtualTransaction
+		case "transactionid":
+			xid := strings.TrimPrefix(r.Target, "transaction ")
+			if xid != x.XID {
+				x.SubXIDs = append(x.SubXIDs, xid)
+			}
+		}
+	}
+	for _, x := range byPID {
+		sort.Strings(x.SubXIDs)
+		g.Xacts = append(g.Xacts, *x)
+	}
+	sort.Slice(g.Xacts, func(i, j int) bool { return g.Xacts[i].PID < g.Xacts[j].PID })
+
+	for _, w := range rows {
+		if w.Granted {
+			continue
+		}
+		for _, h := range rows {
+			if !h.Granted || h.Tag != w.Tag || h.VirtualTransaction == w.VirtualTransaction {
+				continue
+			}
+			if Conflicts(w.Mode, h.Mode) {
+				g.Edges = append(g.Edges, WaitEdge{
+					Waiter: w.PID,
+					Holder: h.PID,
+					Mode:   w.Mode,
+					Target: w.Target,
+				})
+			}
+		}
+	}
+	return g
+}
+
+// Owner returns the top level transaction of the backend which was assigned
+// xid, either directly or for one of its subtransactions.
+func (g LockGraph) Owner(xid string) (Xacts, bool) {
+	for _, x := range g.Xacts {
+		if x.XID == xid {
+			return x, true
+		}
+		for _, sub := range x.SubXIDs {
+			if sub == xid {
+				return x, true
+			}
+		}
+	}
+	return Xacts{}, false
+}
+
+// FindCycle returns the pids of a wait-for cycle, or nil when the graph has
+// none.
+func (g LockGraph) FindCycle() []int {
+	next := map[int][]int{}
+	for _, e := range g.Edges {
+		next[e.Waiter] = append(next[e.Waiter], e.Holder)
+	}
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[int]int{}
+	var path []int
+	var visit func(pid int) []int
+	visit = func(pid int) []int {
+		state[pid] = visiting
+		path = append(path, pid)
+		for _, holder := range next[pid] {
+			switch state[holder] {
+			case visiting:
+				for i, p := range path {
+					if p == holder {
+						return append(append([]int{}, path[i:]...), holder)
+					}
+				}
+			case unvisited:
+				if cycle := visit(holder); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[pid] = visited
+		return nil
+	}
+
+	var waiters []int
+	for pid := range next {
+		waiters = append(waiters, pid)
+	}
+	sort.Ints(waiters)
+	for _, pid := range waiters {
+		if state[pid] == unvisited {
+			if cycle := visit(pid); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// MergeGraphs merges the wait-for graphs of several instances into a graph of
+// application sessions. sessions maps the backend pids of each instance to
+// the application session using them, a session with connections to several
+// instances is a single node of the merged graph. A cycle in the merged graph
+// is a deadlock none of the instances can detect on its own.
+func MergeGraphs(names []string, graphs []LockGraph, sessions []map[int]int) LockGraph {
+	var merged LockGraph
+	for i, g := range graphs {
+		for _, e := range g.Edges {
+			waiter, ok := sessions[i][e.Waiter]
+			if !ok {
+				continue
+			}
+			holder, ok := sessions[i][e.Holder]
+			if !ok {
+				continue
+			}
+			merged.Edges = append(merged.Edges, WaitEdge{
+				Waiter: waiter,
+				Holder: holder,
+				Mode:   e.Mode,
+				Target: names[i] + " " + e.Target,
+			})
+		}
+	}
+	return merged
+}
@@ -0,0 +1,84 @@
+package lockmon
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildLockGraph(t *testing.T) {
+	rows := []lockRow{
+		{PID: 10, LockType: "virtualxid", Mode: "ExclusiveLock", Granted: true, VirtualTransaction: "3/1", Tag: "virtualxid:3/1", BackendXID: "100"},
+		{PID: 10, LockType: "transactionid", Mode: "ExclusiveLock", Granted: true, VirtualTransaction: "3/1", Tag: "transactionid:100", Target: "transaction 100", BackendXID: "100"},
+		{PID: 10, LockType: "transactionid", Mode: "ExclusiveLock", Granted: true, VirtualTransaction: "3/1", Tag: "transactionid:101", Target: "transaction 101", BackendXID: "100"},
+		{PID: 10, LockType: "relation", Mode: "RowExclusiveLock", Granted: true, VirtualTransaction: "3/1", Tag: "relation:1:16386", Target: "relation accounts", BackendXID: "100"},
+		{PID: 20, LockType: "virtualxid", Mode: "ExclusiveLock", Granted: true, VirtualTransaction: "4/1", Tag: "virtualxid:4/1", BackendXID: "200"},
+		{PID: 20, LockType: "transactionid", Mode: "ExclusiveLock", Granted: true, VirtualTransaction: "4/1", Tag: "transactionid:200", Target: "transaction 200", BackendXID: "200"},
+		{PID: 20, LockType: "relation", Mode: "RowExclusiveLock", Granted: true, VirtualTransaction: "4/1", Tag: "relation:1:16386", Target: "relation accounts", BackendXID: "200"},
+		// 20 waits on the subtransaction 101 of 10, and 10 on 20.
+		{PID: 20, LockType: "transactionid", Mode: "ShareLock", Granted: false, VirtualTransaction: "4/1", Tag: "transactionid:101", Target: "transaction 101"},
+		{PID: 10, LockType: "transactionid", Mode: "ShareLock", Granted: false, VirtualTransaction: "3/1", Tag: "transactionid:200", Target: "transaction 200"},
+		// A prepared transaction holds a lock 30 waits on, RowExclusiveLock
+		// does not conflict with RowExclusiveLock.
+		{PID: 0, LockType: "relation", Mode: "AccessExclusiveLock", Granted: true, VirtualTransaction: "-1/300", Tag: "relation:1:16390", Target: "relation orders"},
+		{PID: 30, LockType: "relation", Mode: "RowExclusiveLock", Granted: false, VirtualTransaction: "5/1", Tag: "relation:1:16390", Target: "relation orders"},
+		{PID: 40, LockType: "relation", Mode: "RowExclusiveLock", Granted: false, VirtualTransaction: "6/1", Tag: "relation:1:16386", Target: "relation accounts"},
+	}
+	g := buildLockGraph(rows)
+
+	wantXacts := []Xacts{
+		{PID: 10, VirtualXID: "3/1", XID: "100", SubXIDs: []string{"101"}},
+		{PID: 20, VirtualXID: "4/1", XID: "200"},
+	}
+	if !reflect.DeepEqual(g.Xacts, wantXacts) {
+		t.Errorf("Xacts = %+v, want %+v", g.Xacts, wantXacts)
+	}
+	wantEdges := []WaitEdge{
+		{Waiter: 20, Holder: 10, Mode: "ShareLock", Target: "transaction 101"},
+		{Waiter: 10, Holder: 20, Mode: "ShareLock", Target: "transaction 200"},
+		{Waiter: 30, Holder: 0, Mode: "RowExclusiveLock", Target: "relation orders"},
+	}
+	if !reflect.DeepEqual(g.Edges, wantEdges) {
+		t.Errorf("Edges = %+v, want %+v", g.Edges, wantEdges)
+	}
+	if x, ok := g.Owner("101"); !ok || x.PID != 10 {
+		t.Errorf("Owner(101) = %+v, %v, want pid 10", x, ok)
+	}
+	if cycle := g.FindCycle(); !reflect.DeepEqual(cycle, []int{10, 20, 10}) {
+		t.Errorf("FindCycle() = %v, want [10 20 10]", cycle)
+	}
+}
+
+func TestFindCycle(t *testing.T) {
+	tests := []struct {
+		name  string
+		edges []WaitEdge
+		want  []int
+	}{
+		{"no edges", nil, nil},
+		{"chain", []WaitEdge{{Waiter: 1, Holder: 2}, {Waiter: 2, Holder: 3}}, nil},
+		{"cycle behind a waiter", []WaitEdge{{Waiter: 1, Holder: 2}, {Waiter: 2, Holder: 3}, {Waiter: 3, Holder: 2}}, []int{2, 3, 2}},
+		{"three sessions", []WaitEdge{{Waiter: 3, Holder: 1}, {Waiter: 1, Holder: 2}, {Waiter: 2, Holder: 3}}, []int{1, 2, 3, 1}},
+	}
+	for _, tt := range tests {
+		if got := (LockGraph{Edges: tt.edges}).FindCycle(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: FindCycle() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMergeGraphs(t *testing.T) {
+	a := LockGraph{Edges: []WaitEdge{{Waiter: 11, Holder: 12, Mode: "ShareLock", Target: "transaction 5"}}}
+	b := LockGraph{Edges: []WaitEdge{{Waiter: 22, Holder: 21, Mode: "ShareLock", Target: "transaction 7"}, {Waiter: 23, Holder: 21}}}
+	sessions := []map[int]int{{11: 1, 12: 2}, {21: 1, 22: 2}}
+	g := MergeGraphs([]string{"a", "b"}, []LockGraph{a, b}, sessions)
+	want := []WaitEdge{
+		{Waiter: 1, Holder: 2, Mode: "ShareLock", Target: "a transaction 5"},
+		{Waiter: 2, Holder: 1, Mode: "ShareLock", Target: "b transaction 7"},
+	}
+	if !reflect.DeepEqual(g.Edges, want) {
+		t.Errorf("Edges = %+v, want %+v", g.Edges, want)
+	}
+	if cycle := g.FindCycle(); !reflect.DeepEqual(cycle, []int{1, 2, 1}) {
+		t.Errorf("FindCycle() = %v, want [1 2 1]", cycle)
+	}
+}
@@ -0,0 +1,103 @@
+package lockmon
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ServerDeadlock is a deadlock parsed from the server log. Unlike the error
+// returned to the client, the DETAIL in the log includes the query of every
+// process in the cycle.
+type ServerDeadlock struct {
+	DeadlockDetail
+	Queries   map[int]string
+	Statement string
+}
+
+var (
+	// dockerTimestampRe matches the timestamp docker adds to every log line.
+	dockerTimestampRe = regexp.MustCompile(`\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d(\.\d+)?Z (.*)$`)
+	logMessageRe      = regexp.MustCompile(`(?:^|\s)(ERROR|DETAIL|HINT|CONTEXT|STATEMENT|LOG|FATAL|PANIC|WARNING|NOTICE|INFO|DEBUG\d?):  (.*)$`)
+	processQueryRe    = regexp.MustCompile(`^Process (\d+): (.*)$`)
+)
+
+// LogParser collects "deadlock detected" errors from server log
+// lines. A message continues over the lines which follow it without a
+// severity, and an error is followed by its DETAIL, HINT, CONTEXT and
+// STATEMENT messages.
+type LogParser struct {
+	fields map[string]*strings.Builder
+	field  string
+}
+
+// Line parses the next line of the log and returns a deadlock once all of its
+// messages have been read.
+func (p *LogParser) Line(line string) (ServerDeadlock, bool) {
+	if m := dockerTimestampRe.FindStringSubmatch(line); m != nil {
+		line = m[2]
+	}
+	m := logMessageRe.FindStringSubmatch(line)
+	if m == nil {
+		if p.fields != nil && p.field != "" {
+			p.fields[p.field].WriteString("\n" + strings.TrimSpace(line))
+		}
+		return ServerDeadlock{}, false
+	}
+
+	severity, text := m[1], m[2]
+	switch severity {
+	case "DETAIL", "HINT", "CONTEXT", "STATEMENT":
+		if p.fields != nil {
+			p.field = severity
+			p.fields[severity] = &strings.Builder{}
+			p.fields[severity].WriteString(text)
+		}
+		return ServerDeadlock{}, false
+	}
+
+	d, ok := p.Flush()
+	if severity == "ERROR" && text == "deadlock detected" {
+		p.fields = map[string]*strings.Builder{}
+	}
+	return d, ok
+}
+
+// Flush returns the deadlock being parsed, if any.
+func (p *LogParser) Flush() (ServerDeadlock, bool) {
+	if p.fields == nil {
+		return ServerDeadlock{}, false
+	}
+	field := func(name string) string {
+		if b, ok := p.fields[name]; ok {
+			return b.String()
+		}
+		return ""
+	}
+	d := ServerDeadlock{
+		DeadlockDetail: ParseDeadlockDetail(field("DETAIL"), field("CONTEXT")),
+		Queries:        parseProcessQueries(field("DETAIL")),
+		Statement:      field("STATEMENT"),
+	}
+	p.fields = nil
+	p.field = ""
+	return d, true
+}
+
+// parseProcessQueries returns the queries from "Process 63: ALTER TABLE ..."
+// lines of a deadlock DETAIL, a query continues until the next process.
+func parseProcessQueries(detail string) map[int]string {
+	queries := map[int]string{}
+	pid := 0
+	for _, line := range strings.Split(detail, "\n") {
+		if m := processQueryRe.FindStringSubmatch(line); m != nil {
+			pid, _ = strconv.Atoi(m[1])
+			queries[pid] = m[2]
+			continue
+		}
+		if pid != 0 && !deadlockWaitRe.MatchString(line) {
+			queries[pid] += "\n" + line
+		}
+	}
+	return queries
+}
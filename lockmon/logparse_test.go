@@ -0,0 +1,105 @@
+package lockmon
+
+import (
+	"strings"
+	"testing"
+)
+
+// dockerLog is a deadlock as written to the server log and read through
+// docker logs with timestamps, continuation lines start with a tab.
+const dockerLog = `2026-10-18T10:00:00.100000000Z 2026-10-18 10:00:00.100 UTC [63] LOG:  statement: BEGIN
+2026-10-18T10:00:01.000000000Z 2026-10-18 10:00:01.000 UTC [63] ERROR:  deadlock detected
+2026-10-18T10:00:01.000000000Z 2026-10-18 10:00:01.000 UTC [63] DETAIL:  Process 63 waits for ShareLock on transaction 1234; blocked by process 65.
+2026-10-18T10:00:01.000000000Z 	Process 65 waits for ShareLock on transaction 1233; blocked by process 63.
+2026-10-18T10:00:01.000000000Z 	Process 63: UPDATE accounts SET balance = 1 WHERE id = 2;
+2026-10-18T10:00:01.000000000Z 	Process 65: UPDATE accounts
+2026-10-18T10:00:01.000000000Z 		SET balance = 2 WHERE id = 1;
+2026-10-18T10:00:01.000000000Z 2026-10-18 10:00:01.000 UTC [63] HINT:  See server log for query details.
+2026-10-18T10:00:01.000000000Z 2026-10-18 10:00:01.000 UTC [63] CONTEXT:  while updating tuple (0,2) in relation "accounts"
+2026-10-18T10:00:01.000000000Z 2026-10-18 10:00:01.000 UTC [63] STATEMENT:  UPDATE accounts SET balance = 1 WHERE id = 2;
+2026-10-18T10:00:01.200000000Z 2026-10-18 10:00:01.200 UTC [65] LOG:  duration: 1000.123 ms`
+
+func parseLog(log string, flush bool) []ServerDeadlock {
+	var p LogParser
+	var found []ServerDeadlock
+	for _, line := range strings.Split(log, "\n") {
+		if d, ok := p.Line(line); ok {
+			found = append(found, d)
+		}
+	}
+	if flush {
+		if d, ok := p.Flush(); ok {
+			found = append(found, d)
+		}
+	}
+	return found
+}
+
+func TestLogParser(t *testing.T) {
+	found := parseLog(dockerLog, false)
+	if len(found) != 1 {
+		t.Fatalf("got %d deadlocks, want 1", len(found))
+	}
+	d := found[0]
+	if len(d.Waits) != 2 || d.Waits[0].PID != 63 || d.Waits[0].BlockedBy != 65 || d.Waits[1].PID != 65 || d.Waits[1].BlockedBy != 63 {
+		t.Errorf("waits = %+v", d.Waits)
+	}
+	for _, w := range d.Waits {
+		if w.LockType != "transactionid" {
+			t.Errorf("LockType = %q, want transactionid", w.LockType)
+		}
+	}
+	if got, want := d.Queries[63], "UPDATE accounts SET balance = 1 WHERE id = 2;"; got != want {
+		t.Errorf("query of 63 = %q, want %q", got, want)
+	}
+	if got, want := d.Queries[65], "UPDATE accounts\nSET balance = 2 WHERE id = 1;"; got != want {
+		t.Errorf("query of 65 = %q, want %q", got, want)
+	}
+	if d.Relation != "accounts" || d.Tuple != "(0,2)" {
+		t.Errorf("row = %q %q, want accounts (0,2)", d.Relation, d.Tuple)
+	}
+	if d.Statement != "UPDATE accounts SET balance = 1 WHERE id = 2;" {
+		t.Errorf("Statement = %q", d.Statement)
+	}
+}
+
+func TestLogParserFlush(t *testing.T) {
+	// The deadlock is the last message of the log, only Flush returns it.
+	log := strings.Join(strings.Split(dockerLog, "\n")[:10], "\n")
+	if found := parseLog(log, false); len(found) != 0 {
+		t.Fatalf("got %d deadlocks before Flush, want 0", len(found))
+	}
+	if found := parseLog(log, true); len(found) != 1 {
+		t.Fatalf("got %d deadlocks after Flush, want 1", len(found))
+	}
+}
+
+func TestLogParserPlainLog(t *testing.T) {
+	// A server log file without docker timestamps, with two deadlocks in a
+	// row and an unrelated error.
+	log := `2026-10-18 10:00:00.000 UTC [70] ERROR:  relation "missing" does not exist
+2026-10-18 10:00:00.000 UTC [70] STATEMENT:  SELECT * FROM missing;
+2026-10-18 10:00:01.000 UTC [71] ERROR:  deadlock detected
+2026-10-18 10:00:01.000 UTC [71] DETAIL:  Process 71 waits for ExclusiveLock on advisory lock [12138,0,1,1]; blocked by process 72.
+	Process 72 waits for ExclusiveLock on advisory lock [12138,0,2,1]; blocked by process 71.
+	Process 71: SELECT pg_advisory_lock(2);
+	Process 72: SELECT pg_advisory_lock(1);
+2026-10-18 10:00:02.000 UTC [73] ERROR:  deadlock detected
+2026-10-18 10:00:02.000 UTC [73] DETAIL:  Process 73 waits for AccessExclusiveLock on relation 16386 of database 12138; blocked by process 74.
+	Process 74 waits for AccessShareLock on relation 16390 of database 12138; blocked by process 73.
+	Process 73: ALTER TABLE accounts ADD COLUMN c int;
+	Process 74: SELECT * FROM orders;`
+	found := parseLog(log, true)
+	if len(found) != 2 {
+		t.Fatalf("got %d deadlocks, want 2", len(found))
+	}
+	if w := found[0].Waits[0]; w.LockType != "advisory" || w.Target != "advisory lock [12138,0,1,1]" {
+		t.Errorf("first wait = %+v", w)
+	}
+	if w := found[1].Waits[1]; w.LockType != "relation" || w.Mode != "AccessShareLock" {
+		t.Errorf("second wait = %+v", w)
+	}
+	if got := found[1].Queries[74]; got != "SELECT * FROM orders;" {
+		t.Errorf("query of 74 = %q", got)
+	}
+}
@@ -0,0 +1,256 @@
+// Package lockmon samples the locks of a Postgres server from pg_locks, builds
+// wait-for graphs from them and parses the deadlocks Postgres reports to
+// clients and in its log.
+package lockmon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// lockTargetSQL describes the object locked by a row of pg_locks l joined
+// with pg_class c, in the words of DescribeLockTag() which Postgres uses in
+// the DETAIL of deadlock errors. Relation names are used in place of oids
+// when they can be resolved.
+const lockTargetSQL = `CASE l.locktype
+	WHEN 'relation' THEN 'relation ' || coalesce(c.relname::text, l.relation::text)
+	WHEN 'extend' THEN 'extension of relation ' || coalesce(c.relname::text, l.relation::text)
+	WHEN 'frozenid' THEN 'pg_database.datfrozenxid of database ' || l.database
+	WHEN 'page' THEN format('page %s of relation %s', l.page, coalesce(c.relname::text, l.relation::text))
+	WHEN 'tuple' THEN format('tuple (%s,%s) of relation %s', l.page, l.tuple, coalesce(c.relname::text, l.relation::text))
+	WHEN 'transactionid' THEN 'transaction ' || l.transactionid::text
+	WHEN 'virtualxid' THEN 'virtual transaction ' || l.virtualxid
+	WHEN 'spectoken' THEN format('speculative token %s of transaction %s', l.objid, l.transactionid)
+	WHEN 'object' THEN format('object %s of class %s of database %s', l.objid, l.classid, l.database)
+	WHEN 'userlock' THEN format('user lock [%s,%s,%s]', l.database, l.classid, l.objid)
+	WHEN 'advisory' THEN format('advisory lock [%s,%s,%s,%s]', l.database, l.classid, l.objid, l.objsubid)
+	ELSE l.locktype
+	END`
+
+// HeldLock is a granted lock from pg_locks.
+type HeldLock struct {
+	LockType      string `db:"locktype"`
+	Mode          string
+	Relation      int
+	RelationName  string `db:"relname"`
+	TransactionID string `db:"transactionid"`
+	Target        string
+}
+
+func (l HeldLock) String() string {
+	return fmt.Sprintf("%s on %s", l.Mode, l.Target)
+}
+
+// SessionLocks returns the locks granted to the backend pid. Relation names
+// are only resolved for relations of the database db is connected to.
+func SessionLocks(ctx context.Context, db *sqlx.DB, pid int) ([]HeldLock, error) {
+	var locks []HeldLock
+	err := db.SelectContext(ctx, &locks, `
+	SELECT l.locktype, l.mode,
+		coalesce(l.relation, 0)::int AS relation,
+		coalesce(c.relname, '') AS relname,
+		coalesce(l.transactionid::text, '') AS transactionid,
+		`+lockTargetSQL+` AS target
+	FROM pg_locks l
+	LEFT JOIN pg_class c ON c.oid = l.relation
+	WHERE l.pid = $1 AND l.granted
+	ORDER BY l.locktype, c.relname, l.mode;`, pid)
+	return locks, err
+}
+
+// NewLocks returns the locks in after which are not in before.
+func NewLocks(before, after []HeldLock) []HeldLock {
+	var added []HeldLock
+	for _, a := range after {
+		found := false
+		for _, b := range before {
+			if a == b {
+				found = true
+				break
+			}
+		}
+		if !found {
+			added = append(added, a)
+		}
+	}
+	return added
+}
+
+// DeadlockWait is one edge of the cycle reported in the DETAIL of a deadlock
+// error, e.g. "Process 63 waits for AccessExclusiveLock on relation 16386 of
+// database 12138; blocked by process 65."
+type DeadlockWait struct {
+	PID       int
+	Mode      string
+	LockType  string
+	Target    string
+	BlockedBy int
+}
+
+// DeadlockDetail is the parsed content of a deadlock_detected error.
+type DeadlockDetail struct {
+	Waits []DeadlockWait
+	// Relation and Tuple are the relation and ctid of the tuple being
+	// locked, when the deadlock was on a row lock.
+	Relation string
+	Tuple    string
+	// Where is the context of the statement, including trigger and foreign key
+	// actions run on behalf of the statement.
+	Where string
+}
+
+var (
+	deadlockWaitRe     = regexp.MustCompile(`Process (\d+) waits for (\w+) on ([^;]+); blocked by process (\d+)\.`)
+	deadlockRelationRe = regexp.MustCompile(`tuple (\([^)]*\)) in relation "([^"]+)"`)
+	relationOIDRe      = regexp.MustCompile(`relation (\d+) of database \d+$`)
+)
+
+// lockTargets match the descriptions of DescribeLockTag() to the locktype
+// column of pg_locks.
+var lockTargets = []struct {
+	lockType string
+	re       *regexp.Regexp
+}{
+	{"relation", regexp.MustCompile(`^relation \S+( of database \d+)?$`)},
+	{"extend", regexp.MustCompile(`^extension of relation `)},
+	{"frozenid", regexp.MustCompile(`^pg_database\.datfrozenxid of database \d+$`)},
+	{"page", regexp.MustCompile(`^page \d+ of relation `)},
+	{"tuple", regexp.MustCompile(`^tuple \(\d+,\d+\) of relation `)},
+	{"transactionid", regexp.MustCompile(`^transaction \d+$`)},
+	{"virtualxid", regexp.MustCompile(`^virtual transaction -?\d+/\d+$`)},
+	{"spectoken", regexp.MustCompile(`^speculative token \d+ of transaction \d+$`)},
+	{"object", regexp.MustCompile(`^object \d+ of class \d+ of database \d+$`)},
+	{"userlock", regexp.MustCompile(`^user lock \[\d+,\d+,\d+\]$`)},
+	{"advisory", regexp.MustCompile(`^advisory lock \[\d+,\d+,\d+,\d+\]$`)},
+	{"applytransaction", regexp.MustCompile(`^remote transaction \d+ of subscription \d+ of database \d+$`)},
+}
+
+// TargetLockType returns the locktype of a lock description, or "" when it is
+// not recognized.
+func TargetLockType(target string) string {
+	for _, t := range lockTargets {
+		if t.re.MatchString(target) {
+			return t.lockType
+		}
+	}
+	return ""
+}
+
+// ParseDeadlock parses err if it is a deadlock_detected error.
+func ParseDeadlock(err error) (DeadlockDetail, bool) {
+	var errPq *pq.Error
+	if !errors.As(err, &errPq) || errPq.Code != "40P01" {
+		return DeadlockDetail{}, false
+	}
+	return ParseDeadlockDetail(errPq.Detail, errPq.Where), true
+}
+
+// ParseDeadlockDetail parses the DETAIL and CONTEXT of a deadlock error.
+func ParseDeadlockDetail(detail, where string) DeadlockDetail {
+	d := DeadlockDetail{Where: where}
+	for _, m := range deadlockWaitRe.FindAllStringSubmatch(detail, -1) {
+		pid, _ := strconv.Atoi(m[1])
+		blockedBy, _ := strconv.Atoi(m[4])
+		d.Waits = append(d.Waits, DeadlockWait{
+			PID:       pid,
+			Mode:      m[2],
+			LockType:  TargetLockType(m[3]),
+			Target:    m[3],
+			BlockedBy: blockedBy,
+		})
+	}
+	if m := deadlockRelationRe.FindStringSubmatch(where); m != nil {
+		d.Tuple, d.Relation = m[1], m[2]
+	}
+	return d
+}
+
+// Holds reports whether l is the lock a deadlock wait is blocked on, or when
+// the wait is on a row of relation, a lock on that relation. Row locks are not
+// in pg_locks, a transactionid wait only matches the lock taken by the first
+// write of the holder, which is not necessarily the write of the row.
+func (w DeadlockWait) Holds(l HeldLock, relation string) bool {
+	switch w.LockType {
+	case "relation":
+		m := relationOIDRe.FindStringSubmatch(w.Target)
+		return m != nil && l.LockType == "relation" && strconv.Itoa(l.Relation) == m[1] && Conflicts(l.Mode, w.Mode)
+	case "extend", "page", "tuple":
+		m := relationOIDRe.FindStringSubmatch(w.Target)
+		return m != nil && l.LockType == "relation" && strconv.Itoa(l.Relation) == m[1]
+	case "transactionid":
+		if relation != "" {
+			// Row locks are taken with a ROW SHARE or ROW EXCLUSIVE lock on
+			// the relation, skip plain reads of it.
+			return l.RelationName == relation && l.Mode != "AccessShareLock"
+		}
+		return "transaction "+l.TransactionID == w.Target && l.LockType == "transactionid"
+	}
+	return l.LockType == w.LockType && l.Target == w.Target
+}
+
+// DescribeTarget replaces the relation oids of a lock description from a
+// deadlock error with relation names.
+func DescribeTarget(target string, names map[int]string) string {
+	m := relationOIDRe.FindStringSubmatchIndex(target)
+	if m == nil {
+		return target
+	}
+	oid, _ := strconv.Atoi(target[m[2]:m[3]])
+	name, ok := names[oid]
+	if !ok {
+		return target
+	}
+	return target[:m[0]] + "relation " + name
+}
+
+// PreparedXact is a transaction prepared for two-phase commit, with the locks
+// it keeps holding until COMMIT PREPARED or ROLLBACK PREPARED.
+type PreparedXact struct {
+	GID         string `db:"gid"`
+	Transaction string
+	Owner       string
+	Database    string
+	Prepared    time.Time
+	Locks       string
+}
+
+func (p PreparedXact) String() string {
+	return fmt.Sprintf("%q xid:%s owner:%s database:%s age:%s locks:[%s]",
+		p.GID, p.Transaction, p.Owner, p.Database,
+		time.Since(p.Prepared).Round(time.Second), p.Locks)
+}
+
+// PreparedXacts lists the prepared transactions of all databases. Their locks
+// belong to no backend, pg_locks shows them with a NULL pid and a virtual
+// transaction of -1/xid. Relations of other databases are shown as oids.
+func PreparedXacts(ctx context.Context, db *sqlx.DB) ([]PreparedXact, error) {
+	var xacts []PreparedXact
+	err := db.SelectContext(ctx, &xacts, `
+	SELECT p.gid, p.transaction::text AS transaction, p.owner, p.database, p.prepared,
+		coalesce(string_agg(l.mode || ' on ' || l.locktype ||
+			coalesce(' ' || l.relation::regclass::text, '') ||
+			coalesce(' ' || l.transactionid::text, ''), ', '), '') AS locks
+	FROM pg_prepared_xacts p
+	LEFT JOIN pg_locks l ON l.virtualtransaction = '-1/' || p.transaction::text
+	GROUP BY p.gid, p.transaction, p.owner, p.database, p.prepared
+	ORDER BY p.prepared;`)
+	return xacts, err
+}
+
+// ServerVersion returns the server_version_num of the server, e.g. 90524 for
+// 9.5.24 and 140005 for 14.5.
+func ServerVersion(ctx context.Context, db *sqlx.DB) (int, error) {
+	var version string
+	err := db.GetContext(ctx, &version, "SHOW server_version_num")
+	if err != nil {
+		return 0, fmt.Errorf("unable to get server version: %w", err)
+	}
+	return strconv.Atoi(version)
+}
@@ -0,0 +1,141 @@
+package lockmon
+
+import (
+	"testing"
+)
+
+func TestTargetLockType(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		// DescribeLockTag() in deadlock errors.
+		{"relation 16386 of database 12138", "relation"},
+		{"extension of relation 16386 of database 12138", "extend"},
+		{"pg_database.datfrozenxid of database 12138", "frozenid"},
+		{"page 0 of relation 16386 of database 12138", "page"},
+		{"tuple (0,1) of relation 16386 of database 12138", "tuple"},
+		{"transaction 1234", "transactionid"},
+		{"virtual transaction 3/42", "virtualxid"},
+		{"virtual transaction -1/1234", "virtualxid"},
+		{"speculative token 1 of transaction 1234", "spectoken"},
+		{"object 16390 of class 1259 of database 12138", "object"},
+		{"user lock [12138,1,2]", "userlock"},
+		{"advisory lock [12138,0,42,1]", "advisory"},
+		{"remote transaction 1234 of subscription 16400 of database 12138", "applytransaction"},
+		// lockTargetSQL with resolved relation names.
+		{"relation accounts", "relation"},
+		{"extension of relation accounts", "extend"},
+		{"page 3 of relation accounts", "page"},
+		{"tuple (0,1) of relation accounts", "tuple"},
+		{"unknown locktype 12", ""},
+	}
+	for _, tt := range tests {
+		if got := TargetLockType(tt.target); got != tt.want {
+			t.Errorf("TargetLockType(%q) = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestParseDeadlockDetail(t *testing.T) {
+	detail := "Process 63 waits for ShareLock on transaction 1234; blocked by process 65.\n" +
+		"Process 65 waits for AccessExclusiveLock on relation 16386 of database 12138; blocked by process 63."
+	where := "while updating tuple (0,2) in relation \"accounts\"\nSQL statement \"UPDATE accounts SET balance = 0\""
+	d := ParseDeadlockDetail(detail, where)
+
+	want := []DeadlockWait{
+		{PID: 63, Mode: "ShareLock", LockType: "transactionid", Target: "transaction 1234", BlockedBy: 65},
+		{PID: 65, Mode: "AccessExclusiveLock", LockType: "relation", Target: "relation 16386 of database 12138", BlockedBy: 63},
+	}
+	if len(d.Waits) != len(want) {
+		t.Fatalf("got %d waits, want %d: %+v", len(d.Waits), len(want), d.Waits)
+	}
+	for i := range want {
+		if d.Waits[i] != want[i] {
+			t.Errorf("wait %d = %+v, want %+v", i, d.Waits[i], want[i])
+		}
+	}
+	if d.Relation != "accounts" || d.Tuple != "(0,2)" {
+		t.Errorf("row = %q %q, want accounts (0,2)", d.Relation, d.Tuple)
+	}
+	if d.Where != where {
+		t.Errorf("Where = %q, want %q", d.Where, where)
+	}
+}
+
+func TestParseDeadlockDetailEveryLockType(t *testing.T) {
+	targets := []string{
+		"relation 16386 of database 12138",
+		"extension of relation 16386 of database 12138",
+		"pg_database.datfrozenxid of database 12138",
+		"page 0 of relation 16386 of database 12138",
+		"tuple (0,1) of relation 16386 of database 12138",
+		"transaction 1234",
+		"virtual transaction 3/42",
+		"speculative token 1 of transaction 1234",
+		"object 16390 of class 1259 of database 12138",
+		"user lock [12138,1,2]",
+		"advisory lock [12138,0,42,1]",
+		"remote transaction 1234 of subscription 16400 of database 12138",
+	}
+	for _, target := range targets {
+		detail := "Process 1 waits for ExclusiveLock on " + target + "; blocked by process 2.\n" +
+			"Process 2 waits for ShareLock on transaction 99; blocked by process 1."
+		d := ParseDeadlockDetail(detail, "")
+		if len(d.Waits) != 2 {
+			t.Errorf("%s: got %d waits, want 2", target, len(d.Waits))
+			continue
+		}
+		if d.Waits[0].Target != target || d.Waits[0].LockType == "" {
+			t.Errorf("%s: parsed %+v", target, d.Waits[0])
+		}
+	}
+}
+
+func TestHolds(t *testing.T) {
+	accounts := HeldLock{LockType: "relation", Mode: "RowExclusiveLock", Relation: 16386, RelationName: "accounts", Target: "relation accounts"}
+	readAccounts := HeldLock{LockType: "relation", Mode: "AccessShareLock", Relation: 16386, RelationName: "accounts", Target: "relation accounts"}
+	xact := HeldLock{LockType: "transactionid", Mode: "ExclusiveLock", TransactionID: "1234", Target: "transaction 1234"}
+	advisory := HeldLock{LockType: "advisory", Mode: "ExclusiveLock", Target: "advisory lock [12138,0,42,1]"}
+
+	tests := []struct {
+		name     string
+		wait     DeadlockWait
+		lock     HeldLock
+		relation string
+		want     bool
+	}{
+		{"relation", DeadlockWait{LockType: "relation", Mode: "AccessExclusiveLock", Target: "relation 16386 of database 12138"}, accounts, "", true},
+		{"relation not conflicting", DeadlockWait{LockType: "relation", Mode: "RowExclusiveLock", Target: "relation 16386 of database 12138"}, readAccounts, "", false},
+		{"other relation", DeadlockWait{LockType: "relation", Mode: "AccessExclusiveLock", Target: "relation 1 of database 12138"}, accounts, "", false},
+		{"tuple", DeadlockWait{LockType: "tuple", Mode: "ExclusiveLock", Target: "tuple (0,1) of relation 16386 of database 12138"}, accounts, "", true},
+		{"transaction", DeadlockWait{LockType: "transactionid", Mode: "ShareLock", Target: "transaction 1234"}, xact, "", true},
+		{"other transaction", DeadlockWait{LockType: "transactionid", Mode: "ShareLock", Target: "transaction 1"}, xact, "", false},
+		{"row of relation", DeadlockWait{LockType: "transactionid", Mode: "ShareLock", Target: "transaction 1"}, accounts, "accounts", true},
+		{"row of read relation", DeadlockWait{LockType: "transactionid", Mode: "ShareLock", Target: "transaction 1"}, readAccounts, "accounts", false},
+		{"advisory", DeadlockWait{LockType: "advisory", Mode: "ExclusiveLock", Target: "advisory lock [12138,0,42,1]"}, advisory, "", true},
+	}
+	for _, tt := range tests {
+		if got := tt.wait.Holds(tt.lock, tt.relation); got != tt.want {
+			t.Errorf("%s: Holds() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDescribeTarget(t *testing.T) {
+	names := map[int]string{16386: "accounts"}
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"relation 16386 of database 12138", "relation accounts"},
+		{"tuple (0,1) of relation 16386 of database 12138", "tuple (0,1) of relation accounts"},
+		{"relation 1 of database 12138", "relation 1 of database 12138"},
+		{"transaction 1234", "transaction 1234"},
+	}
+	for _, tt := range tests {
+		if got := DescribeTarget(tt.target, names); got != tt.want {
+			t.Errorf("DescribeTarget(%q) = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
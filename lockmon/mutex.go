@@ -0,0 +1,67 @@
+package lockmon
+
+import (
+	"sync"
+)
+
+// TrackedMutex is a sync.Mutex which records its holder and waiters by the
+// backend pid of the session the goroutine uses. Its waits can be added to a
+// LockGraph sampled from pg_locks, so cycles through both in-process and
+// database locks are found.
+type TrackedMutex struct {
+	name string
+	mu   sync.Mutex
+
+	state sync.Mutex
+	// locked is kept apart from holder, pid 0 is a valid holder, the pid
+	// LockGraph gives prepared transactions.
+	locked  bool
+	holder  int
+	waiters map[int]bool
+}
+
+// NewTrackedMutex returns an unlocked mutex, name identifies it in the graph.
+func NewTrackedMutex(name string) *TrackedMutex {
+	return &TrackedMutex{name: name, waiters: map[int]bool{}}
+}
+
+// Lock locks the mutex for the session with backend pid.
+func (m *TrackedMutex) Lock(pid int) {
+	m.state.Lock()
+	m.waiters[pid] = true
+	m.state.Unlock()
+
+	m.mu.Lock()
+
+	m.state.Lock()
+	delete(m.waiters, pid)
+	m.locked, m.holder = true, pid
+	m.state.Unlock()
+}
+
+// Unlock unlocks the mutex.
+func (m *TrackedMutex) Unlock() {
+	m.state.Lock()
+	m.locked, m.holder = false, 0
+	m.state.Unlock()
+	m.mu.Unlock()
+}
+
+// Edges returns a WaitEdge from every waiting pid to the holder.
+func (m *TrackedMutex) Edges() []WaitEdge {
+	m.state.Lock()
+	defer m.state.Unlock()
+	var edges []WaitEdge
+	if !m.locked {
+		return nil
+	}
+	for pid := range m.waiters {
+		edges = append(edges, WaitEdge{
+			Waiter: pid,
+			Holder: m.holder,
+			Mode:   "Mutex",
+			Target: "mutex " + m.name,
+		})
+	}
+	return edges
+}
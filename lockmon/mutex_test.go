@@ -0,0 +1,36 @@
+package lockmon
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTrackedMutexEdges(t *testing.T) {
+	for _, holder := range []int{0, 42} {
+		m := NewTrackedMutex("accounts")
+		if edges := m.Edges(); edges != nil {
+			t.Errorf("unlocked mutex has edges %v", edges)
+		}
+		m.Lock(holder)
+		locked := make(chan struct{})
+		go func() {
+			m.Lock(7)
+			close(locked)
+		}()
+		want := []WaitEdge{{Waiter: 7, Holder: holder, Mode: "Mutex", Target: "mutex accounts"}}
+		deadline := time.Now().Add(5 * time.Second)
+		for !reflect.DeepEqual(m.Edges(), want) {
+			if time.Now().After(deadline) {
+				t.Fatalf("holder %d: edges %v, want %v", holder, m.Edges(), want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		m.Unlock()
+		<-locked
+		if edges := m.Edges(); edges != nil {
+			t.Errorf("holder %d: edges %v without waiters", holder, edges)
+		}
+		m.Unlock()
+	}
+}
@@ -0,0 +1,86 @@
+package lockmon
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Session is a client backend from pg_stat_activity.
+type Session struct {
+	PID             int
+	Database        string `db:"datname"`
+	UserName        string `db:"usename"`
+	ApplicationName string `db:"application_name"`
+	State           string
+	// WaitEventType and WaitEvent are what the backend waits for, before 9.6
+	// only lock waits are known and have WaitEventType Lock.
+	WaitEventType string  `db:"wait_event_type"`
+	WaitEvent     string  `db:"wait_event"`
+	Query         string  `db:"query"`
+	XactSeconds   float64 `db:"xact_seconds"`
+	QuerySeconds  float64 `db:"query_seconds"`
+}
+
+// XactAge returns how long the transaction of the session has been open, 0
+// outside of a transaction.
+func (s Session) XactAge() time.Duration {
+	return time.Duration(s.XactSeconds * float64(time.Second))
+}
+
+// QueryAge returns how long ago the current or last query started.
+func (s Session) QueryAge() time.Duration {
+	return time.Duration(s.QuerySeconds * float64(time.Second))
+}
+
+// sessionsQuery returns the query of SampleSessions for the server version,
+// 9.6 replaced the waiting column with wait events.
+func sessionsQuery(version int) string {
+	wait := "coalesce(wait_event_type, '') AS wait_event_type, coalesce(wait_event, '') AS wait_event"
+	if version < 90600 {
+		wait = "CASE WHEN waiting THEN 'Lock' ELSE '' END AS wait_event_type, '' AS wait_event"
+	}
+	return `SELECT pid, coalesce(datname, '') AS datname, coalesce(usename, '') AS usename,
+		application_name, state, ` + wait + `, query,
+		coalesce(extract(epoch FROM now() - xact_start), 0) AS xact_seconds,
+		coalesce(extract(epoch FROM now() - query_start), 0) AS query_seconds
+	FROM pg_stat_activity
+	WHERE state IS NOT NULL AND pid <> pg_backend_pid()
+	ORDER BY xact_start NULLS LAST, pid;`
+}
+
+// SampleSessions returns the client backends of the server other than the
+// one of db, the oldest transaction first.
+func SampleSessions(ctx context.Context, db *sqlx.DB, version int) ([]Session, error) {
+	var sessions []Session
+	err := db.SelectContext(ctx, &sessions, sessionsQuery(version))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read pg_stat_activity: %w", err)
+	}
+	return sessions, nil
+}
+
+// CancelBackend cancels the current query of the backend pid.
+func CancelBackend(ctx context.Context, db *sqlx.DB, pid int) error {
+	return signalBackend(ctx, db, "pg_cancel_backend", pid)
+}
+
+// TerminateBackend closes the connection of the backend pid, rolling back its
+// transaction.
+func TerminateBackend(ctx context.Context, db *sqlx.DB, pid int) error {
+	return signalBackend(ctx, db, "pg_terminate_backend", pid)
+}
+
+func signalBackend(ctx context.Context, db *sqlx.DB, function string, pid int) error {
+	var ok bool
+	err := db.GetContext(ctx, &ok, "SELECT "+function+"($1)", pid)
+	if err != nil {
+		return fmt.Errorf("%s(%d): %w", function, pid, err)
+	}
+	if !ok {
+		return fmt.Errorf("%s(%d): no such backend", function, pid)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package lockmon
+
+import (
+	"context"
+	"database/sql"
+	"runtime"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// PoolStall is reported by WatchPool when goroutines wait for a connection
+// from a pool which has every connection in use and makes no progress.
+type PoolStall struct {
+	Stats  sql.DBStats
+	Stacks string
+}
+
+// WatchPool samples db.Stats() every interval. Once a goroutine waited for a
+// connection since start, the stats taken before the queries which may stall
+// were issued, while all connections are in use, and the stats stay unchanged
+// for samples intervals, the stall is sent on the returned channel. Postgres
+// never sees this kind of deadlock, each connection is only waiting on the
+// application.
+func WatchPool(ctx context.Context, db *sqlx.DB, start sql.DBStats, interval time.Duration, samples int) <-chan PoolStall {
+	stalled := make(chan PoolStall, 1)
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		last := start
+		unchanged := 0
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			stats := db.Stats()
+			exhausted := stats.MaxOpenConnections > 0 &&
+				stats.InUse >= stats.MaxOpenConnections &&
+				stats.WaitCount > start.WaitCount
+			if exhausted && stats == last {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+			last = stats
+			if unchanged >= samples {
+				stalled <- PoolStall{Stats: stats, Stacks: goroutineStacks()}
+				return
+			}
+		}
+	}()
+	return stalled
+}
+
+func goroutineStacks() string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
@@ -0,0 +1,62 @@
+package lockmon
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// idleConnector opens connections which cannot run statements, enough to
+// exhaust a pool.
+type idleConnector struct{}
+
+func (idleConnector) Connect(context.Context) (driver.Conn, error) { return idleConn{}, nil }
+func (idleConnector) Driver() driver.Driver                        { return nil }
+
+type idleConn struct{}
+
+func (idleConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (idleConn) Close() error                        { return nil }
+func (idleConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func TestWatchPool(t *testing.T) {
+	db := sqlx.NewDb(sql.OpenDB(idleConnector{}), "idle")
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	held, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+
+	start := db.Stats()
+	waiting := make(chan struct{})
+	go func() {
+		defer close(waiting)
+		conn, err := db.Conn(ctx)
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	// The wait starts before the watchdog, it still counts from start.
+	for db.Stats().WaitCount == start.WaitCount {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case stall := <-WatchPool(ctx, db, start, 10*time.Millisecond, 3):
+		if stall.Stats.InUse != 1 || stall.Stats.WaitCount != start.WaitCount+1 || stall.Stacks == "" {
+			t.Errorf("stall %+v", stall.Stats)
+		}
+	case <-ctx.Done():
+		t.Fatal("no stall reported")
+	}
+	cancel()
+	<-waiting
+}
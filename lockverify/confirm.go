@@ -0,0 +1,49 @@
+package lockverify
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/13rac1/pg-deadlocks/lockanalysis"
+	"github.com/13rac1/pg-deadlocks/scenario"
+)
+
+// InversionScenario returns the schedule of the two scripts which should
+// deadlock on inv: A runs up to WantA, B up to WantB, then A and B request
+// the inverted locks and both finish.
+func InversionScenario(name string, setup, a, b []string, inv lockanalysis.Inversion) scenario.Scenario {
+	var steps []scenario.Step
+	add := func(session int, statements []string) {
+		for _, query := range statements {
+			steps = append(steps, scenario.Step{Session: session, SQL: query})
+		}
+	}
+	add(0, a[:inv.WantA.Statement])
+	add(1, b[:inv.WantB.Statement])
+	add(0, a[inv.WantA.Statement:inv.WantA.Statement+1])
+	add(1, b[inv.WantB.Statement:inv.WantB.Statement+1])
+	add(0, a[inv.WantA.Statement+1:])
+	add(1, b[inv.WantB.Statement+1:])
+	return scenario.Scenario{
+		Name:        name,
+		Description: "schedule predicted to deadlock on " + inv.WantA.String() + " and " + inv.WantB.String(),
+		Setup:       setup,
+		Steps:       steps,
+	}
+}
+
+// ConfirmDeadlocks runs the schedule of every inversion, see
+// InversionScenario, and returns the results.
+func ConfirmDeadlocks(ctx context.Context, admin *sqlx.DB, dsn string, setup, a, b []string, inversions []lockanalysis.Inversion) ([]scenario.Result, error) {
+	var results []scenario.Result
+	for i, inv := range inversions {
+		r, err := scenario.Run(ctx, admin, dsn, InversionScenario(fmt.Sprintf("predict/%d", i+1), setup, a, b, inv))
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
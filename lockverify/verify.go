@@ -0,0 +1,150 @@
+// Package lockverify runs the predictions of lockanalysis against a server:
+// Verify compares the locks predicted for statements with pg_locks, and
+// ConfirmDeadlocks runs the schedules predicted to deadlock.
+package lockverify
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/13rac1/pg-deadlocks/lockanalysis"
+	"github.com/13rac1/pg-deadlocks/lockmon"
+	"github.com/13rac1/pg-deadlocks/scenario"
+)
+
+// Check compares the predicted locks of a statement with the locks it was
+// granted.
+type Check struct {
+	Statement lockanalysis.Statement
+	// Observed has the strongest mode granted on every table, and on every
+	// relation of the prediction, sampled from pg_locks before the
+	// transaction of the statement is rolled back.
+	Observed []lockanalysis.TableLock
+	// Mismatches describe the tables whose observed mode differs from the
+	// prediction. Row lock strengths are not in pg_locks and not checked.
+	Mismatches []string
+	// Err is the error of the statement.
+	Err error
+	// Skipped is true for statements which cannot run in a transaction
+	// block, their locks are released before they can be sampled.
+	Skipped bool
+}
+
+// OK reports whether the prediction matched the granted locks.
+func (c Check) OK() bool {
+	return c.Err == nil && !c.Skipped && c.Statement.Known && len(c.Mismatches) == 0
+}
+
+// Verify runs every statement in its own transaction, in a new database
+// created with setup, and compares the locks granted to its session in
+// pg_locks with the prediction of lockanalysis.Analyze for the server
+// version.
+func Verify(ctx context.Context, admin *sqlx.DB, dsn string, setup, statements []string) ([]Check, error) {
+	db, closeDB, err := scenario.OpenDB(ctx, admin, dsn, setup)
+	if err != nil {
+		return nil, err
+	}
+	defer closeDB()
+	version, err := lockmon.ServerVersion(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	conn, _, err := scenario.OpenSession(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var checks []Check
+	for _, query := range statements {
+		c := Check{Statement: lockanalysis.Analyze(query, version)}
+		if !c.Statement.Transactional {
+			c.Skipped = true
+			checks = append(checks, c)
+			continue
+		}
+		_, err = conn.ExecContext(ctx, "BEGIN")
+		if err != nil {
+			return nil, err
+		}
+		_, c.Err = conn.ExecContext(ctx, query)
+		if c.Err == nil {
+			c.Observed, c.Err = ownTableLocks(ctx, conn, c.Statement)
+		}
+		_, err = conn.ExecContext(ctx, "ROLLBACK")
+		if err != nil {
+			return nil, err
+		}
+		if c.Err == nil && c.Statement.Known {
+			c.Mismatches = mismatches(c.Statement, c.Observed)
+		}
+		checks = append(checks, c)
+	}
+	return checks, nil
+}
+
+// ownTableLocks returns the strongest relation lock granted to the session on
+// every table, and on the other relations the prediction names, e.g. an
+// index. Catalogs and the transient tables of table rewrites are left out.
+func ownTableLocks(ctx context.Context, conn *sql.Conn, predicted lockanalysis.Statement) ([]lockanalysis.TableLock, error) {
+	rows, err := conn.QueryContext(ctx, `
+	SELECT c.relname, c.relkind, l.mode
+	FROM pg_locks l
+	JOIN pg_class c ON c.oid = l.relation
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE l.pid = pg_backend_pid() AND l.locktype = 'relation' AND l.granted
+		AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+		AND n.nspname NOT LIKE 'pg_toast%'
+		AND c.relname NOT LIKE 'pg\_temp\_%'
+	ORDER BY c.relname`)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read own locks: %w", err)
+	}
+	defer rows.Close()
+	var locks []lockanalysis.TableLock
+	for rows.Next() {
+		var table, kind, mode string
+		err = rows.Scan(&table, &kind, &mode)
+		if err != nil {
+			return nil, err
+		}
+		if kind == "r" || kind == "p" || kind == "m" || predicted.Mode(table) != "" {
+			locks = lock(locks, table, mode)
+		}
+	}
+	return locks, rows.Err()
+}
+
+// lock adds mode on table to locks, combined with the mode already held on
+// the table.
+func lock(locks []lockanalysis.TableLock, table, mode string) []lockanalysis.TableLock {
+	for i, l := range locks {
+		if l.Table == table {
+			locks[i].Mode = lockanalysis.Strongest(l.Mode, mode)
+			return locks
+		}
+	}
+	return append(locks, lockanalysis.TableLock{Table: table, Mode: mode})
+}
+
+func mismatches(predicted lockanalysis.Statement, observed []lockanalysis.TableLock) []string {
+	var found []string
+	got := lockanalysis.Statement{Tables: observed}
+	for _, l := range predicted.Tables {
+		if mode := got.Mode(l.Table); mode != l.Mode {
+			if mode == "" {
+				mode = "no lock"
+			}
+			found = append(found, fmt.Sprintf("%s: predicted %s, observed %s", l.Table, l.Mode, mode))
+		}
+	}
+	for _, l := range observed {
+		if predicted.Mode(l.Table) == "" {
+			found = append(found, fmt.Sprintf("%s: observed %s, not predicted", l.Table, l.Mode))
+		}
+	}
+	return found
+}
@@ -0,0 +1,34 @@
+package lockverify_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/13rac1/pg-deadlocks/deadlocktest"
+	"github.com/13rac1/pg-deadlocks/lockanalysis"
+	"github.com/13rac1/pg-deadlocks/lockverify"
+	"github.com/13rac1/pg-deadlocks/pgcontainer"
+)
+
+// TestVerifyExamples checks the predictions of the examples against the locks
+// of a real server. It is skipped when Docker is not available.
+func TestVerifyExamples(t *testing.T) {
+	srv := deadlocktest.Start(t, pgcontainer.Config{})
+	checks, err := lockverify.Verify(context.Background(), srv.DB, srv.DSN, lockanalysis.ExampleSetup, lockanalysis.Examples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != len(lockanalysis.Examples) {
+		t.Fatalf("%d checks for %d examples", len(checks), len(lockanalysis.Examples))
+	}
+	for _, c := range checks {
+		// Statements which cannot run in a transaction block are not
+		// verified.
+		if c.Skipped {
+			continue
+		}
+		if !c.OK() {
+			t.Errorf("%s: predicted %s, observed %v, error %v: %v", c.Statement.SQL, c.Statement, c.Observed, c.Err, c.Mismatches)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Package pgcontainer runs Postgres in Docker containers for reproducing
+// deadlocks.
+package pgcontainer
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+)
+
+// StopTimeout is how long a container has to stop before it is killed.
+var StopTimeout = 10 * time.Second
+
+// NewClient returns a Docker client configured from the environment.
+func NewClient() (*Client, error) {
+	cli, err := client.NewEnvClient()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create docker client: %w", err)
+	}
+	return &Client{Client: *cli, Out: ioutil.Discard}, nil
+}
+
+// Client is a Docker client. Out receives the progress of pulling images and
+// of starting and stopping containers.
+type Client struct {
+	client.Client
+	Out io.Writer
+}
+
+// RunContainer pulls and starts image, ports maps host ports to container
+// ports. Host ports are only bound on the loopback interface, Docker picks a
+// free port for an empty host port.
+func (d *Client) RunContainer(ctx context.Context, image string, ports map[string]string, env []string, cmd []string) (*container.ContainerCreateCreatedBody, error) {
+	imageName, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil, fmt.Errorf("unable to normalize image name: %w", err)
+	}
+	fullName := imageName.String()
+
+	// TODO: cli.ImageList() to only download if not available
+	out, err := d.ImagePull(ctx, fullName, types.ImagePullOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to pull image: %w", err)
+	}
+	defer out.Close()
+
+	io.Copy(d.Out, out)
+
+	container, err := d.createNewContainer(ctx, fullName, ports, env, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("unable create container: %w", err)
+	}
+	err = d.ContainerStart(ctx, container.ID, types.ContainerStartOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to start the container: %w", err)
+	}
+	fmt.Fprintf(d.Out, "container %s is started\n", container.ID)
+	return container, nil
+}
+
+func (d *Client) createNewContainer(ctx context.Context, image string, ports map[string]string, env []string, cmd []string) (*container.ContainerCreateCreatedBody, error) {
+	portBinding := nat.PortMap{}
+	for hostPort, port := range ports {
+		hostBinding := nat.PortBinding{
+			HostIP:   "127.0.0.1",
+			HostPort: hostPort,
+		}
+		containerPort, err := nat.NewPort("tcp", port)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get the port: %w", err)
+		}
+		portBinding[containerPort] = append(portBinding[containerPort], hostBinding)
+	}
+	cont, err := d.ContainerCreate(
+		ctx,
+		&container.Config{
+			Image: image,
+			Env:   env,
+			Cmd:   cmd,
+		},
+		&container.HostConfig{
+			PortBindings: portBinding,
+		}, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return &cont, nil
+}
+
+// HostPort returns the host port bound to the TCP port of the container id.
+func (d *Client) HostPort(ctx context.Context, id, port string) (string, error) {
+	info, err := d.ContainerInspect(ctx, id)
+	if err != nil {
+		return "", fmt.Errorf("unable to inspect container: %w", err)
+	}
+	if info.NetworkSettings != nil {
+		for _, binding := range info.NetworkSettings.Ports[nat.Port(port+"/tcp")] {
+			if binding.HostPort != "" {
+				return binding.HostPort, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("port %s of container %s is not bound", port, id)
+}
+
+// RemoveContainer stops and removes the container id.
+func (d *Client) RemoveContainer(ctx context.Context, id string) error {
+	fmt.Fprintf(d.Out, "container %s is stopping\n", id)
+	err := d.ContainerStop(ctx, id, &StopTimeout)
+	if err != nil {
+		return fmt.Errorf("failed stopping container: %w", err)
+	}
+	fmt.Fprintf(d.Out, "container %s is stopped\n", id)
+
+	err = d.ContainerRemove(ctx, id, types.ContainerRemoveOptions{
+		RemoveVolumes: true,
+		// RemoveLinks=true causes "Error response from daemon: Conflict, cannot
+		// remove the default name of the container"
+		RemoveLinks: false,
+		Force:       false,
+	})
+	if err != nil {
+		return fmt.Errorf("failed removing container: %w", err)
+	}
+	fmt.Fprintf(d.Out, "container %s is removed\n", id)
+	return nil
+}
+
+// FollowLogs calls line with every line of the container logs until the
+// container stops.
+func (d *Client) FollowLogs(ctx context.Context, id string, line func(string)) error {
+	out, err := d.ContainerLogs(ctx, id, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+		Timestamps: true,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to follow logs: %w", err)
+	}
+	defer out.Close()
+
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		line(scanner.Text())
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,162 @@
+package pgcontainer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	_ "github.com/lib/pq"
+)
+
+const (
+	// SKIP LOCKED requires 9.5
+	DefaultImage = "postgres:9.5-alpine"
+)
+
+// Instance is a started Postgres container. DSN connects to its postgres
+// database, DB is a pool connected with it.
+type Instance struct {
+	Name string
+	ID   string
+	Addr string
+	DSN  string
+	DB   *sqlx.DB
+}
+
+// Config configures a Postgres container. HostPort is the port of 127.0.0.1
+// the server listens on, Docker picks a free one when it is empty. Log is
+// called with every line of the server log when set.
+type Config struct {
+	Image    string
+	HostPort string
+	Log      func(line string)
+}
+
+// Start runs a Postgres container listening on the host port of config and
+// waits until it accepts connections.
+func Start(ctx context.Context, docker *Client, config Config) (Instance, error) {
+	if config.Image == "" {
+		config.Image = DefaultImage
+	}
+	ports := map[string]string{config.HostPort: "5432"}
+	env := []string{"POSTGRES_PASSWORD=postgres"}
+	// Prepared transactions are disabled by default.
+	cmd := []string{"postgres", "-c", "max_prepared_transactions=10"}
+
+	pgContainer, err := docker.RunContainer(ctx, config.Image, ports, env, cmd)
+	if err != nil {
+		return Instance{}, fmt.Errorf("error running container: %w", err)
+	}
+	if config.Log != nil {
+		go docker.FollowLogs(ctx, pgContainer.ID, config.Log)
+	}
+	hostPort, err := docker.HostPort(ctx, pgContainer.ID, "5432")
+	if err != nil {
+		docker.RemoveContainer(ctx, pgContainer.ID)
+		return Instance{}, err
+	}
+	addr := "127.0.0.1:" + hostPort
+
+	db, err := WaitForPostgresReady(ctx, addr, docker.Out)
+	if err != nil {
+		docker.RemoveContainer(ctx, pgContainer.ID)
+		return Instance{}, fmt.Errorf("failed waiting on Postgres: %w", err)
+	}
+	return Instance{Name: addr, ID: pgContainer.ID, Addr: addr, DSN: DBURL(addr, "postgres"), DB: db}, nil
+}
+
+// DBURL returns the connection URL of database name of the server at addr.
+func DBURL(addr, name string) string {
+	return fmt.Sprintf("postgres://postgres:postgres@%s/%s?sslmode=disable", addr, name)
+}
+
+// WaitForPostgresReady waits for the server at addr to accept connections and
+// returns a pool connected to the postgres database. Progress is written to
+// out.
+func WaitForPostgresReady(ctx context.Context, addr string, out io.Writer) (*sqlx.DB, error) {
+	err := WaitForPort(addr, out)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintln(out, "connecting DB")
+	// sqlx.Connect calls Ping() and will fail when the DB is not ready, so
+	// manually ping the DB until ready.
+	db, err := sqlx.Open("postgres", DBURL(addr, "postgres"))
+	if err != nil {
+		return nil, err
+	}
+
+	err = PingDB(ctx, db, out)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// WaitForPort waits for addr to accept TCP connections.
+func WaitForPort(addr string, out io.Writer) error {
+	count := 0
+	fmt.Fprintln(out, "checking for open port")
+
+	conn, err := net.DialTimeout("tcp", addr, 1*time.Second)
+	for err != nil {
+		count++
+		if count > 10 {
+			return fmt.Errorf("cannot connect to server: %w", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		fmt.Fprintln(out, "retrying Port")
+		conn, err = net.DialTimeout("tcp", addr, 1*time.Second)
+	}
+	conn.Close()
+	fmt.Fprintln(out, "port connected")
+	return nil
+}
+
+// PingDB pings db until the server has started up.
+func PingDB(ctx context.Context, db *sqlx.DB, out io.Writer) error {
+	fmt.Fprintln(out, "pinging DB")
+	err := db.PingContext(ctx)
+
+	retry := func(err error) error {
+		fmt.Fprintf(out, "retrying: %s\n", err)
+		time.Sleep(500 * time.Millisecond)
+		return db.PingContext(ctx)
+	}
+
+	for err != nil {
+		// Connections to a PG database fail with "connection reset by peer" or
+		// "EOF" until the database has started to respond, then connections will
+		// fail with "the database system is starting up" until the database is
+		// ready.
+		var errNo syscall.Errno
+		if errors.As(err, &errNo) {
+			if errNo == 0x68 { //"connection reset by peer" on Linux
+				err = retry(err)
+				continue
+			}
+		}
+		if err == io.EOF {
+			err = retry(err)
+			continue
+		}
+		var errPq *pq.Error
+		if errors.As(err, &errPq) {
+			// "the database system is starting up"
+			if errPq.Code == "57P03" {
+				err = retry(err)
+				continue
+			}
+		}
+		return err
+	}
+	fmt.Fprintln(out, "DB pinged")
+	return nil
+}
@@ -0,0 +1,54 @@
+package scenario
+
+// alterTableSteps are the steps of alter-table, alterTableStep is the index of
+// the ALTER TABLE.
+var alterTableSteps = []Step{
+	{1, `BEGIN`},
+	{0, `BEGIN`},
+	{1, `INSERT INTO users(first_name, last_name, email)
+		VALUES ('test1', 'test1', 'test1@example.com') RETURNING "id";`},
+	{0, `INSERT INTO users(first_name, last_name, email)
+		VALUES ('test2', 'test2', 'test2@example.com') RETURNING "id";`},
+	// Conflicts with INSERT of test1@example.com
+	{0, `ALTER TABLE users ADD COLUMN counter TEXT;`},
+	// Conflicts with INSERT of test2@example.com
+	{1, `INSERT INTO users(first_name, last_name, email)
+		VALUES ('test3', 'test3', 'test2@example.com') RETURNING "id";`},
+	{1, `COMMIT`},
+	{0, `COMMIT`},
+}
+
+const alterTableStep = 4
+
+func init() {
+	Register(Scenario{
+		Name: "alter-table",
+		Description: "ALTER TABLE in a transaction which already inserted a row " +
+			"deadlocks with a concurrent INSERT of a conflicting unique key",
+		Setup: []string{`
+		CREATE TABLE users (
+			id SERIAL PRIMARY KEY,
+			first_name TEXT,
+			last_name TEXT,
+			email TEXT,
+			UNIQUE (email)
+		)`},
+		Steps: alterTableSteps,
+		Fixes: []Scenario{
+			{
+				Name: "split-migration",
+				Description: "the INSERT is committed before the ALTER TABLE runs in its " +
+					"own transaction, the conflicting INSERT fails with unique_violation",
+				Steps: InsertSteps(alterTableSteps, alterTableStep,
+					Step{0, `COMMIT`}, Step{0, `BEGIN`}),
+			},
+			{
+				Name: "lock-timeout",
+				Description: "the ALTER TABLE gives up with lock_not_available before " +
+					"the deadlock detector runs",
+				Steps: InsertSteps(alterTableSteps, alterTableStep,
+					Step{0, `SET LOCAL lock_timeout = '50ms'`}),
+			},
+		},
+	})
+}
@@ -0,0 +1,96 @@
+package scenario
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// VariantStats are the results of the runs of a scenario or one of its fixes.
+type VariantStats struct {
+	Name      string
+	Runs      int
+	Deadlocks int
+	Outcomes  map[Outcome]int
+	Total     time.Duration
+	Max       time.Duration
+}
+
+func (v *VariantStats) add(r Result) {
+	v.Runs++
+	if r.Outcome == OutcomeDeadlock || r.Outcome == OutcomeAppDeadlock {
+		v.Deadlocks++
+	}
+	v.Outcomes[r.Outcome]++
+	v.Total += r.Duration
+	if r.Duration > v.Max {
+		v.Max = r.Duration
+	}
+}
+
+// Mean returns the mean duration of the runs.
+func (v VariantStats) Mean() time.Duration {
+	if v.Runs == 0 {
+		return 0
+	}
+	return v.Total / time.Duration(v.Runs)
+}
+
+// OutcomeCounts lists the count of every outcome, most severe first.
+func (v VariantStats) OutcomeCounts() string {
+	var keys []Outcome
+	for o := range v.Outcomes {
+		keys = append(keys, o)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] > keys[j] })
+	counts := make([]string, len(keys))
+	for i, o := range keys {
+		counts[i] = fmt.Sprintf("%s=%d", o, v.Outcomes[o])
+	}
+	return strings.Join(counts, " ")
+}
+
+// MatchFixed returns the scenarios with fixes whose name matches pattern.
+func MatchFixed(pattern string) ([]Scenario, error) {
+	var matches []Scenario
+	for _, s := range scenarios {
+		if len(s.Fixes) == 0 {
+			continue
+		}
+		ok, err := path.Match(pattern, s.Name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matches = append(matches, s)
+		}
+	}
+	return matches, nil
+}
+
+// Compare runs the scenario and its fixes runs times and returns how often
+// each variant deadlocked and how long it took, the scenario first. The
+// variants are run in turns, each in a fresh database, so all of them run
+// under the same conditions of the container.
+func Compare(ctx context.Context, admin *sqlx.DB, dsn string, s Scenario, runs int) ([]VariantStats, error) {
+	variants := append([]Scenario{s}, s.Fixes...)
+	stats := make([]VariantStats, len(variants))
+	for i, v := range variants {
+		stats[i] = VariantStats{Name: v.Name, Outcomes: map[Outcome]int{}}
+	}
+	for run := 0; run < runs; run++ {
+		for i, v := range variants {
+			r, err := Run(ctx, admin, dsn, v)
+			if err != nil {
+				return nil, err
+			}
+			stats[i].add(r)
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,110 @@
+package scenario
+
+// maintenanceDDL is a maintenance statement run against a table or
+// materialized view while another transaction is still writing.
+type maintenanceDDL struct {
+	name string
+	sql  string
+	// inTx is false for statements which cannot run inside a transaction
+	// block.
+	inTx bool
+	// inflight is the statement of the long running transaction which holds
+	// a lock on the object the DDL needs.
+	inflight string
+}
+
+var maintenanceDDLs = []maintenanceDDL{
+	{"create-index", `CREATE INDEX ON t (v)`, true, insertK1},
+	{"create-index-concurrently", `CREATE INDEX CONCURRENTLY ON t (v)`, false, insertK1},
+	{"reindex", `REINDEX TABLE t`, true, insertK1},
+	{"vacuum-full", `VACUUM FULL t`, false, insertK1},
+	{"cluster", `CLUSTER t USING t_k_key`, true, insertK1},
+	{"truncate", `TRUNCATE t`, true, insertK1},
+	{"refresh-matview", `REFRESH MATERIALIZED VIEW mv`, true, selectMV},
+	{"refresh-matview-concurrently", `REFRESH MATERIALIZED VIEW CONCURRENTLY mv`, true, selectMV},
+}
+
+const (
+	insertK1 = `INSERT INTO t (k, v) VALUES (1, 'in-flight')`
+	selectMV = `SELECT count(*) FROM mv`
+)
+
+var ddlSetup = []string{`
+	CREATE TABLE t (
+		id SERIAL PRIMARY KEY,
+		k INT UNIQUE,
+		v TEXT
+	);
+	CREATE MATERIALIZED VIEW mv AS SELECT id, k FROM t;
+	CREATE UNIQUE INDEX mv_id ON mv (id);
+	CREATE TABLE other (id INT);
+	INSERT INTO other VALUES (1);`,
+}
+
+func init() {
+	for _, ddl := range maintenanceDDLs {
+		Register(ddlBlockedScenario(ddl))
+		if ddl.inTx {
+			Register(ddlInTxScenario(ddl))
+		}
+	}
+
+	Register(Scenario{
+		Name: "ddl/create-index-concurrently/old-snapshot",
+		Description: "CREATE INDEX CONCURRENTLY waits for a transaction holding an " +
+			"old snapshot, even one which never touched the table",
+		Setup: ddlSetup,
+		Steps: []Step{
+			{1, `BEGIN ISOLATION LEVEL REPEATABLE READ`},
+			{1, `SELECT count(*) FROM other`},
+			{0, `CREATE INDEX CONCURRENTLY ON t (v)`},
+			{1, `COMMIT`},
+		},
+	})
+}
+
+// ddlBlockedScenario runs the DDL outside of a transaction while another
+// transaction is in flight. The DDL waits, and a reader arriving afterwards
+// queues behind the DDL when the DDL lock conflicts with it.
+func ddlBlockedScenario(ddl maintenanceDDL) Scenario {
+	return Scenario{
+		Name:        "ddl/" + ddl.name + "/blocked",
+		Description: ddl.sql + " waits for an in-flight transaction",
+		Setup:       ddlSetup,
+		Steps: []Step{
+			{1, `BEGIN`},
+			{1, ddl.inflight},
+			{0, ddl.sql},
+			{2, `SELECT count(*) FROM t`},
+			{1, `COMMIT`},
+		},
+	}
+}
+
+// ddlInTxScenario runs the DDL in a transaction which has already written,
+// the same pattern as the alter-table scenario.
+func ddlInTxScenario(ddl maintenanceDDL) Scenario {
+	steps := []Step{
+		{1, `BEGIN`},
+		{1, ddl.inflight},
+		{0, `BEGIN`},
+		{0, `INSERT INTO t (k, v) VALUES (2, 'migration')`},
+		{0, ddl.sql},
+		// Conflicts with the INSERT of k=2
+		{1, `INSERT INTO t (k, v) VALUES (2, 'in-flight')`},
+		{1, `COMMIT`},
+		{0, `COMMIT`},
+	}
+	return Scenario{
+		Name:        "ddl/" + ddl.name + "/in-tx",
+		Description: ddl.sql + " after an INSERT in the same transaction",
+		Setup:       ddlSetup,
+		Steps:       steps,
+		Fixes: []Scenario{{
+			Name: "split-migration",
+			Description: "the INSERT is committed before " + ddl.sql +
+				" runs in its own transaction",
+			Steps: InsertSteps(steps, 4, Step{0, `COMMIT`}, Step{0, `BEGIN`}),
+		}},
+	}
+}
@@ -0,0 +1,118 @@
+package scenario
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/13rac1/pg-deadlocks/lockmon"
+	"github.com/13rac1/pg-deadlocks/pgcontainer"
+	"github.com/jmoiron/sqlx"
+)
+
+// distributedSetup is run on every instance.
+var distributedSetup = []string{`
+	CREATE TABLE accounts (
+		id INT PRIMARY KEY,
+		balance INT NOT NULL
+	);
+	INSERT INTO accounts VALUES (1, 100);`,
+}
+
+// RunDistributed runs two application sessions which each update a row on
+// both instances, in opposite order. Each instance only sees one backend
+// waiting for another, the cycle is only visible after merging the wait-for
+// graphs of the instances with the application's mapping of connections to
+// sessions.
+func RunDistributed(ctx context.Context, instances []pgcontainer.Instance) (Result, error) {
+	names := make([]string, len(instances))
+	dbs := make([]*sqlx.DB, len(instances))
+	for i, inst := range instances {
+		names[i] = inst.Name
+		db, closeDB, err := OpenDB(ctx, inst.DB, inst.DSN, distributedSetup)
+		if err != nil {
+			return Result{}, fmt.Errorf("%s: %w", inst.Name, err)
+		}
+		defer closeDB()
+		dbs[i] = db
+	}
+
+	// conns[session][instance]
+	conns := make([][]*sql.Conn, 2)
+	sessions := make([]map[int]int, len(instances))
+	for i := range sessions {
+		sessions[i] = map[int]int{}
+	}
+	for s := range conns {
+		for i, db := range dbs {
+			conn, pid, err := OpenSession(ctx, db)
+			if err != nil {
+				return Result{}, fmt.Errorf("%s: %w", names[i], err)
+			}
+			defer conn.Close()
+			defer conn.ExecContext(context.Background(), `ROLLBACK`)
+			conns[s] = append(conns[s], conn)
+			sessions[i][pid] = s
+		}
+	}
+
+	update := func(ctx context.Context, s, i int) StepResult {
+		r := ExecStep(ctx, conns[s][i], s, `UPDATE accounts SET balance = balance + 10 WHERE id = 1`)
+		r.SQL = fmt.Sprintf("/* %s */ %s", names[i], r.SQL)
+		return r
+	}
+
+	start := time.Now()
+	var results []StepResult
+	for s := range conns {
+		for i := range instances {
+			results = append(results, ExecStep(ctx, conns[s][i], s, `BEGIN`))
+		}
+	}
+	// Session 0 updates instance 0 then 1, session 1 updates 1 then 0.
+	results = append(results, update(ctx, 0, 0), update(ctx, 1, 1))
+
+	waitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan StepResult, 2)
+	go func() { done <- update(waitCtx, 0, 1) }()
+	go func() { done <- update(waitCtx, 1, 0) }()
+
+	r := Result{Scenario: "distributed/opposite-order"}
+	var err error
+	r.ServerVersion, err = lockmon.ServerVersion(ctx, dbs[0])
+	if err != nil {
+		return Result{}, err
+	}
+	deadline := time.Now().Add(BlockTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(DeadlockTimeout)
+		graphs := make([]lockmon.LockGraph, len(dbs))
+		for i, db := range dbs {
+			g, err := lockmon.SampleLockGraph(ctx, db)
+			if err != nil {
+				return Result{}, fmt.Errorf("%s: %w", names[i], err)
+			}
+			graphs[i] = g
+		}
+		merged := lockmon.MergeGraphs(names, graphs, sessions)
+		if merged.FindCycle() != nil {
+			r.Graph = &merged
+			r.Outcome = OutcomeAppDeadlock
+			break
+		}
+	}
+	cancel()
+	for i := 0; i < 2; i++ {
+		sr := <-done
+		sr.Blocked = r.Outcome == OutcomeAppDeadlock
+		results = append(results, sr)
+	}
+	if c := Classify(results); c > r.Outcome {
+		r.Outcome = c
+	}
+	r.Steps = results
+	r.Duration = time.Since(start)
+	return r, nil
+}
@@ -0,0 +1,137 @@
+package scenario
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// sessionLocalRe matches statements which neither take locks nor a snapshot,
+// their order relative to the statements of other sessions does not matter.
+// Isolation levels above READ COMMITTED take the snapshot at the first query
+// of the transaction, not at BEGIN.
+var sessionLocalRe = regexp.MustCompile(`(?i)^(BEGIN|START TRANSACTION|SET|SHOW|RESET)\b`)
+
+// notSessionLocalRe matches the SET statements which do not commute: SET
+// CONSTRAINTS ... IMMEDIATE fires the deferred constraint triggers, which
+// take locks, and SET TRANSACTION SNAPSHOT imports a snapshot.
+var notSessionLocalRe = regexp.MustCompile(`(?i)^SET\s+(CONSTRAINTS|TRANSACTION)\b`)
+
+func sessionLocal(query string) bool {
+	query = strings.TrimSpace(query)
+	return sessionLocalRe.MatchString(query) && !notSessionLocalRe.MatchString(query)
+}
+
+// Scripts returns the statements of every session of steps in order, indexed
+// by session.
+func Scripts(steps []Step) [][]string {
+	var scripts [][]string
+	for _, st := range steps {
+		for len(scripts) <= st.Session {
+			scripts = append(scripts, nil)
+		}
+		scripts[st.Session] = append(scripts[st.Session], st.SQL)
+	}
+	return scripts
+}
+
+// Interleavings returns the schedules of the session scripts which keep the
+// order of the statements of every session. Schedules which only differ in
+// the order of a session local statement like BEGIN and a statement of
+// another session are equivalent, only the one running the lower session
+// first is kept. max limits the number of schedules returned, 0 is no limit.
+// The number of pruned schedules is returned too.
+func Interleavings(scripts [][]string, max int) ([][]Step, int) {
+	var (
+		schedules [][]Step
+		pruned    int
+	)
+	next := make([]int, len(scripts))
+	var schedule []Step
+	var visit func(remaining int)
+	visit = func(remaining int) {
+		if max > 0 && len(schedules) >= max {
+			return
+		}
+		if remaining == 0 {
+			schedules = append(schedules, append([]Step{}, schedule...))
+			return
+		}
+		for s, script := range scripts {
+			if next[s] == len(script) {
+				continue
+			}
+			st := Step{s, script[next[s]]}
+			if n := len(schedule); n > 0 {
+				last := schedule[n-1]
+				if last.Session > s && (sessionLocal(last.SQL) || sessionLocal(st.SQL)) {
+					pruned += countInterleavings(scripts, next, s)
+					continue
+				}
+			}
+			schedule = append(schedule, st)
+			next[s]++
+			visit(remaining - 1)
+			next[s]--
+			schedule = schedule[:len(schedule)-1]
+		}
+	}
+	total := 0
+	for _, script := range scripts {
+		total += len(script)
+	}
+	visit(total)
+	return schedules, pruned
+}
+
+// countInterleavings returns the number of schedules of the statements of
+// scripts after next, once the next statement of session first ran.
+func countInterleavings(scripts [][]string, next []int, first int) int {
+	n := 0
+	count := 1
+	for s, script := range scripts {
+		remaining := len(script) - next[s]
+		if s == first {
+			remaining--
+		}
+		for k := 1; k <= remaining; k++ {
+			n++
+			count = count * n / k
+		}
+	}
+	return count
+}
+
+// ScheduleName names a schedule by the session of every step, e.g. s0 s1 s0.
+func ScheduleName(steps []Step) string {
+	names := make([]string, len(steps))
+	for i, st := range steps {
+		names[i] = fmt.Sprintf("s%d", st.Session)
+	}
+	return strings.Join(names, " ")
+}
+
+// Explore runs every schedule of the session scripts returned by
+// Interleavings in a fresh database with setup. The results are named by
+// ScheduleName and sorted by outcome, most severe first.
+func Explore(ctx context.Context, admin *sqlx.DB, dsn string, setup []string, scripts [][]string, max int) ([]Result, int, error) {
+	schedules, pruned := Interleavings(scripts, max)
+	var results []Result
+	for _, steps := range schedules {
+		r, err := Run(ctx, admin, dsn, Scenario{
+			Name:  ScheduleName(steps),
+			Setup: setup,
+			Steps: steps,
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		results = append(results, r)
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Outcome > results[j].Outcome })
+	return results, pruned, nil
+}
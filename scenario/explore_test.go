@@ -0,0 +1,142 @@
+package scenario
+
+import (
+	"reflect"
+	"testing"
+)
+
+// allInterleavings returns every schedule keeping the order of the statements
+// of each session, without pruning.
+func allInterleavings(scripts [][]string) [][]Step {
+	var schedules [][]Step
+	next := make([]int, len(scripts))
+	var schedule []Step
+	var visit func()
+	visit = func() {
+		done := true
+		for s, script := range scripts {
+			if next[s] == len(script) {
+				continue
+			}
+			done = false
+			schedule = append(schedule, Step{s, script[next[s]]})
+			next[s]++
+			visit()
+			next[s]--
+			schedule = schedule[:len(schedule)-1]
+		}
+		if done {
+			schedules = append(schedules, append([]Step{}, schedule...))
+		}
+	}
+	visit()
+	return schedules
+}
+
+// canonical swaps adjacent statements of different sessions, one of them
+// session local, until the lower session runs first. The result is an
+// equivalent schedule.
+func canonical(schedule []Step) []Step {
+	c := append([]Step{}, schedule...)
+	for swapped := true; swapped; {
+		swapped = false
+		for i := 1; i < len(c); i++ {
+			a, b := c[i-1], c[i]
+			if a.Session > b.Session && (sessionLocal(a.SQL) || sessionLocal(b.SQL)) {
+				c[i-1], c[i] = b, a
+				swapped = true
+			}
+		}
+	}
+	return c
+}
+
+func TestInterleavings(t *testing.T) {
+	tests := []struct {
+		name       string
+		scripts    [][]string
+		wantKept   int
+		wantPruned int
+	}{
+		{"one session", [][]string{{"UPDATE a", "UPDATE b"}}, 1, 0},
+		{"two statements each", [][]string{{"UPDATE a", "UPDATE b"}, {"UPDATE b", "UPDATE a"}}, 6, 0},
+		{"three sessions", [][]string{{"UPDATE a"}, {"UPDATE b", "UPDATE c"}, {"UPDATE d"}}, 12, 0},
+		{"begin and commit", [][]string{{"BEGIN", "UPDATE a", "COMMIT"}, {"BEGIN", "UPDATE b", "COMMIT"}}, 6, 14},
+		{"set and show", [][]string{{"SET lock_timeout = '1s'", "UPDATE a"}, {"show lock_timeout", "UPDATE a"}}, 2, 4},
+		{"only local", [][]string{{"BEGIN"}, {"BEGIN"}, {"SET x = 1"}}, 1, 5},
+		{"set constraints", [][]string{{"SET CONSTRAINTS ALL IMMEDIATE", "UPDATE a"}, {"UPDATE a", "UPDATE b"}}, 6, 0},
+		{"set transaction snapshot", [][]string{{"SET TRANSACTION SNAPSHOT '00000003-1'", "SELECT a"}, {"UPDATE a"}}, 3, 0},
+		{"begin and set constraints", [][]string{{"BEGIN", "SET CONSTRAINTS ALL IMMEDIATE"}, {"UPDATE a"}}, 2, 1},
+	}
+	for _, tt := range tests {
+		kept, pruned := Interleavings(tt.scripts, 0)
+		if len(kept) != tt.wantKept || pruned != tt.wantPruned {
+			t.Errorf("%s: %d kept, %d pruned, want %d and %d", tt.name, len(kept), pruned, tt.wantKept, tt.wantPruned)
+		}
+		all := allInterleavings(tt.scripts)
+		if len(kept)+pruned != len(all) {
+			t.Errorf("%s: %d kept and %d pruned of %d schedules", tt.name, len(kept), pruned, len(all))
+		}
+		// Pruning is sound: every schedule is equivalent to a kept one.
+		for _, schedule := range all {
+			c := canonical(schedule)
+			found := false
+			for _, k := range kept {
+				if reflect.DeepEqual(k, c) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				t.Errorf("%s: %s has no equivalent kept schedule", tt.name, ScheduleName(schedule))
+			}
+		}
+	}
+}
+
+func TestSessionLocal(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"BEGIN", true},
+		{"begin isolation level serializable", true},
+		{"START TRANSACTION", true},
+		{"  SET lock_timeout = '1s'", true},
+		{"SET LOCAL statement_timeout = 0", true},
+		{"SET SESSION CHARACTERISTICS AS TRANSACTION ISOLATION LEVEL SERIALIZABLE", true},
+		{"SHOW lock_timeout", true},
+		{"RESET ALL", true},
+		{"SET CONSTRAINTS ALL IMMEDIATE", false},
+		{"set constraints fk DEFERRED", false},
+		{"SET TRANSACTION SNAPSHOT '00000003-1'", false},
+		{"SET TRANSACTION ISOLATION LEVEL SERIALIZABLE", false},
+		{"SETTLE", false},
+		{"UPDATE a SET b = 1", false},
+		{"COMMIT", false},
+	}
+	for _, tt := range tests {
+		if got := sessionLocal(tt.query); got != tt.want {
+			t.Errorf("sessionLocal(%q) = %t, want %t", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestInterleavingsMax(t *testing.T) {
+	scripts := [][]string{{"UPDATE a", "UPDATE b"}, {"UPDATE b", "UPDATE a"}}
+	kept, _ := Interleavings(scripts, 4)
+	if len(kept) != 4 {
+		t.Errorf("got %d schedules, want 4", len(kept))
+	}
+	if got := ScheduleName(kept[0]); got != "s0 s0 s1 s1" {
+		t.Errorf("first schedule %s, want s0 s0 s1 s1", got)
+	}
+}
+
+func TestScripts(t *testing.T) {
+	steps := []Step{{1, "BEGIN"}, {0, "BEGIN"}, {1, "UPDATE a"}, {0, "COMMIT"}, {1, "COMMIT"}}
+	want := [][]string{{"BEGIN", "COMMIT"}, {"BEGIN", "UPDATE a", "COMMIT"}}
+	if got := Scripts(steps); !reflect.DeepEqual(got, want) {
+		t.Errorf("Scripts() = %q, want %q", got, want)
+	}
+}
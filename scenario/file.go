@@ -0,0 +1,62 @@
+package scenario
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// scenarioFile is the JSON encoding of a scenario without Go code, used to
+// save scenarios found by the fuzzer so they can be replayed with run.
+type scenarioFile struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Setup       []string `json:"setup,omitempty"`
+	Steps       []Step   `json:"steps"`
+	Cleanup     []string `json:"cleanup,omitempty"`
+	MinVersion  int      `json:"min_version,omitempty"`
+	MaxVersion  int      `json:"max_version,omitempty"`
+}
+
+// Load reads a scenario saved by Save.
+func Load(name string) (Scenario, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return Scenario{}, err
+	}
+	var f scenarioFile
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		return Scenario{}, fmt.Errorf("unable to parse %s: %w", name, err)
+	}
+	return Scenario{
+		Name:        f.Name,
+		Description: f.Description,
+		Setup:       f.Setup,
+		Steps:       f.Steps,
+		Cleanup:     f.Cleanup,
+		MinVersion:  f.MinVersion,
+		MaxVersion:  f.MaxVersion,
+	}, nil
+}
+
+// Save writes the scenario as JSON. Scenarios with a Run function cannot be
+// saved.
+func Save(name string, s Scenario) error {
+	if s.Run != nil {
+		return fmt.Errorf("scenario %s runs Go code and cannot be saved", s.Name)
+	}
+	b, err := json.MarshalIndent(scenarioFile{
+		Name:        s.Name,
+		Description: s.Description,
+		Setup:       s.Setup,
+		Steps:       s.Steps,
+		Cleanup:     s.Cleanup,
+		MinVersion:  s.MinVersion,
+		MaxVersion:  s.MaxVersion,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, append(b, '\n'), 0644)
+}
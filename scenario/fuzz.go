@@ -0,0 +1,166 @@
+package scenario
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Grammar describes the random transactions generated by the fuzzer. Every
+// session runs one transaction of Statements statements picked from
+// Statements templates, {key} in a template is replaced with a random key
+// from 1 to Keys and {keys} in Setup with Keys.
+type Grammar struct {
+	Setup      []string `json:"setup"`
+	Keys       int      `json:"keys"`
+	Sessions   int      `json:"sessions"`
+	Length     int      `json:"length"`
+	Statements []string `json:"statements"`
+}
+
+// DefaultGrammar updates, inserts, deletes and locks rows of a table of
+// accounts.
+var DefaultGrammar = Grammar{
+	Setup: []string{`
+	CREATE TABLE accounts (
+		id INT PRIMARY KEY,
+		balance INT NOT NULL
+	);
+	INSERT INTO accounts SELECT k, 100 FROM generate_series(1, {keys}) k;`,
+	},
+	Keys:     4,
+	Sessions: 2,
+	Length:   3,
+	Statements: []string{
+		`UPDATE accounts SET balance = balance + 1 WHERE id = {key}`,
+		`SELECT balance FROM accounts WHERE id = {key} FOR UPDATE`,
+		`SELECT balance FROM accounts WHERE id = {key} FOR SHARE`,
+		`DELETE FROM accounts WHERE id = {key}`,
+		`INSERT INTO accounts VALUES ({key}, 0) ON CONFLICT (id) DO UPDATE SET balance = 0`,
+		`SELECT pg_advisory_xact_lock({key})`,
+	},
+}
+
+// LoadGrammar reads a Grammar from a JSON file, unset fields are taken from
+// DefaultGrammar.
+func LoadGrammar(name string) (Grammar, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return Grammar{}, err
+	}
+	var g Grammar
+	err = json.Unmarshal(b, &g)
+	if err != nil {
+		return Grammar{}, fmt.Errorf("unable to parse %s: %w", name, err)
+	}
+	if g.Keys < 0 || g.Sessions < 0 || g.Length < 0 {
+		return Grammar{}, fmt.Errorf("%s: keys, sessions and length must be positive", name)
+	}
+	if g.Setup == nil {
+		g.Setup = DefaultGrammar.Setup
+	}
+	if g.Keys <= 0 {
+		g.Keys = DefaultGrammar.Keys
+	}
+	if g.Sessions <= 0 {
+		g.Sessions = DefaultGrammar.Sessions
+	}
+	if g.Length <= 0 {
+		g.Length = DefaultGrammar.Length
+	}
+	if g.Statements == nil {
+		g.Statements = DefaultGrammar.Statements
+	}
+	err = g.Validate()
+	if err != nil {
+		return Grammar{}, fmt.Errorf("%s: %w", name, err)
+	}
+	return g, nil
+}
+
+// Validate returns an error when Generate cannot generate scenarios from g.
+func (g Grammar) Validate() error {
+	switch {
+	case len(g.Statements) == 0:
+		return errors.New("grammar has no statements")
+	case g.Keys < 1:
+		return errors.New("grammar needs at least one key")
+	case g.Sessions < 1:
+		return errors.New("grammar needs at least one session")
+	case g.Length < 0:
+		return errors.New("grammar has a negative length")
+	}
+	return nil
+}
+
+// Generate returns the scenario of seed: a transaction per session, with the
+// statements of the sessions randomly interleaved. The same seed always
+// generates the same scenario.
+func (g Grammar) Generate(seed int64) Scenario {
+	rnd := rand.New(rand.NewSource(seed))
+	keys := strconv.Itoa(g.Keys)
+	setup := make([]string, len(g.Setup))
+	for i, query := range g.Setup {
+		setup[i] = strings.ReplaceAll(query, "{keys}", keys)
+	}
+
+	scripts := make([][]string, g.Sessions)
+	for s := range scripts {
+		scripts[s] = append(scripts[s], `BEGIN`)
+		for i := 0; i < g.Length; i++ {
+			template := g.Statements[rnd.Intn(len(g.Statements))]
+			key := strconv.Itoa(rnd.Intn(g.Keys) + 1)
+			scripts[s] = append(scripts[s], strings.ReplaceAll(template, "{key}", key))
+		}
+		scripts[s] = append(scripts[s], `COMMIT`)
+	}
+
+	var steps []Step
+	next := make([]int, len(scripts))
+	for remaining := g.Sessions * (g.Length + 2); remaining > 0; remaining-- {
+		var ready []int
+		for s, script := range scripts {
+			if next[s] < len(script) {
+				ready = append(ready, s)
+			}
+		}
+		s := ready[rnd.Intn(len(ready))]
+		steps = append(steps, Step{s, scripts[s][next[s]]})
+		next[s]++
+	}
+	return Scenario{
+		Name:        fmt.Sprintf("fuzz/%d", seed),
+		Description: fmt.Sprintf("generated by fuzz -seed %d", seed),
+		Setup:       setup,
+		Steps:       steps,
+	}
+}
+
+// Fuzz runs the scenarios of iterations seeds starting at seed. ran is called
+// with every scenario and its result, e.g. to report progress and save the
+// scenarios which deadlocked. Fuzzing stops when it returns an error.
+func Fuzz(ctx context.Context, admin *sqlx.DB, dsn string, g Grammar, seed int64, iterations int, ran func(Scenario, Result) error) error {
+	err := g.Validate()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < iterations; i++ {
+		s := g.Generate(seed + int64(i))
+		r, err := Run(ctx, admin, dsn, s)
+		if err != nil {
+			return fmt.Errorf("seed %d: %w", seed+int64(i), err)
+		}
+		err = ran(s, r)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package scenario
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadGrammar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grammar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		json    string
+		wantErr string
+	}{
+		{"defaults", `{}`, ""},
+		{"statements", `{"keys": 2, "statements": ["UPDATE t SET x = 1 WHERE id = {key}"]}`, ""},
+		{"empty statements", `{"statements": []}`, "no statements"},
+		{"negative keys", `{"keys": -1}`, "must be positive"},
+		{"negative sessions", `{"sessions": -2}`, "must be positive"},
+		{"invalid json", `{"keys": "two"}`, "unable to parse"},
+	}
+	for _, tt := range tests {
+		name := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "-")+".json")
+		err := ioutil.WriteFile(name, []byte(tt.json), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g, err := LoadGrammar(name)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: %s", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantErr)
+		case err == nil:
+			// A loaded grammar always generates.
+			g.Generate(1)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	g := DefaultGrammar
+	s := g.Generate(42)
+	if !reflect.DeepEqual(s, g.Generate(42)) {
+		t.Error("the same seed generated different scenarios")
+	}
+	if s.Name != "fuzz/42" {
+		t.Errorf("Name = %q, want fuzz/42", s.Name)
+	}
+	if !strings.Contains(s.Setup[0], "generate_series(1, 4)") {
+		t.Errorf("{keys} not replaced in setup: %s", s.Setup[0])
+	}
+	if len(s.Steps) != g.Sessions*(g.Length+2) {
+		t.Errorf("got %d steps, want %d", len(s.Steps), g.Sessions*(g.Length+2))
+	}
+	for session, script := range Scripts(s.Steps) {
+		if len(script) != g.Length+2 || script[0] != "BEGIN" || script[len(script)-1] != "COMMIT" {
+			t.Errorf("session %d is not one transaction: %q", session, script)
+		}
+		for _, query := range script {
+			if strings.Contains(query, "{key}") {
+				t.Errorf("{key} not replaced: %s", query)
+			}
+		}
+	}
+}
+
+func TestGrammarValidate(t *testing.T) {
+	g := DefaultGrammar
+	g.Statements = []string{}
+	if g.Validate() == nil {
+		t.Error("a grammar without statements is valid")
+	}
+	g = DefaultGrammar
+	g.Keys = 0
+	if g.Validate() == nil {
+		t.Error("a grammar without keys is valid")
+	}
+	if err := DefaultGrammar.Validate(); err != nil {
+		t.Errorf("DefaultGrammar: %s", err)
+	}
+}
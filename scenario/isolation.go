@@ -0,0 +1,72 @@
+package scenario
+
+var isolationSetup = []string{`
+	CREATE TABLE accounts (
+		id INT PRIMARY KEY,
+		balance INT NOT NULL
+	);
+	CREATE TABLE doctors (
+		name TEXT PRIMARY KEY,
+		on_call BOOLEAN NOT NULL
+	);
+	INSERT INTO accounts VALUES (1, 100), (2, 100);
+	INSERT INTO doctors VALUES ('alice', true), ('bob', true);`,
+}
+
+func init() {
+	for _, level := range []string{"REPEATABLE READ", "SERIALIZABLE"} {
+		Register(isolationDeadlockScenario(level))
+	}
+
+	Register(Scenario{
+		Name: "isolation/repeatable-read/concurrent-update",
+		Description: "updating a row changed after the snapshot was taken fails " +
+			"with serialization_failure",
+		Setup: isolationSetup,
+		Steps: []Step{
+			{0, `BEGIN ISOLATION LEVEL REPEATABLE READ`},
+			{0, `SELECT balance FROM accounts WHERE id = 1`},
+			{1, `UPDATE accounts SET balance = balance - 10 WHERE id = 1`},
+			{0, `UPDATE accounts SET balance = balance + 10 WHERE id = 1`},
+			{0, `COMMIT`},
+		},
+	})
+
+	Register(Scenario{
+		Name: "isolation/serializable/write-skew",
+		Description: "two transactions read the on call doctors and each takes " +
+			"one off call, the SIReadLock predicate locks fail the second commit",
+		Setup: isolationSetup,
+		Steps: []Step{
+			{0, `BEGIN ISOLATION LEVEL SERIALIZABLE`},
+			{1, `BEGIN ISOLATION LEVEL SERIALIZABLE`},
+			{0, `SELECT count(*) FROM doctors WHERE on_call`},
+			{1, `SELECT count(*) FROM doctors WHERE on_call`},
+			{0, `UPDATE doctors SET on_call = false WHERE name = 'alice'`},
+			{1, `UPDATE doctors SET on_call = false WHERE name = 'bob'`},
+			{0, `COMMIT`},
+			{1, `COMMIT`},
+		},
+	})
+}
+
+// isolationDeadlockScenario is the classic deadlock of two transactions
+// updating two rows in opposite order. At any isolation level it fails with
+// deadlock_detected and not serialization_failure.
+func isolationDeadlockScenario(level string) Scenario {
+	return Scenario{
+		Name:        "isolation/" + kebabCase(level) + "/deadlock",
+		Description: "rows updated in opposite order at " + level,
+		Setup:       isolationSetup,
+		Steps: []Step{
+			{0, `BEGIN ISOLATION LEVEL ` + level},
+			{1, `BEGIN ISOLATION LEVEL ` + level},
+			{0, `UPDATE accounts SET balance = balance - 10 WHERE id = 1`},
+			{1, `UPDATE accounts SET balance = balance - 10 WHERE id = 2`},
+			{0, `UPDATE accounts SET balance = balance + 10 WHERE id = 2`},
+			{1, `UPDATE accounts SET balance = balance + 10 WHERE id = 1`},
+			{0, `COMMIT`},
+			{1, `COMMIT`},
+		},
+	}
+}
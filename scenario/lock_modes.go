@@ -0,0 +1,81 @@
+package scenario
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// LockModes are the table lock modes accepted by LOCK TABLE, from weakest to
+// strongest.
+var LockModes = []string{
+	"ACCESS SHARE",
+	"ROW SHARE",
+	"ROW EXCLUSIVE",
+	"SHARE UPDATE EXCLUSIVE",
+	"SHARE",
+	"SHARE ROW EXCLUSIVE",
+	"EXCLUSIVE",
+	"ACCESS EXCLUSIVE",
+}
+
+func init() {
+	Register(lockUpgradeScenario("SHARE", "SHARE ROW EXCLUSIVE"))
+}
+
+// lockUpgradeScenario has two transactions take the initial lock mode on the
+// same table and then both upgrade to the upgrade lock mode. It deadlocks when
+// the upgrade mode conflicts with the initial mode held by the other session.
+func lockUpgradeScenario(initial, upgrade string) Scenario {
+	return Scenario{
+		Name: "lock-upgrade/" + kebabCase(initial) + "/" + kebabCase(upgrade),
+		Description: fmt.Sprintf("two transactions hold %s and upgrade to %s",
+			initial, upgrade),
+		Setup: []string{`CREATE TABLE t (id INT)`},
+		Steps: []Step{
+			{0, `BEGIN`},
+			{1, `BEGIN`},
+			{0, `LOCK TABLE t IN ` + initial + ` MODE`},
+			{1, `LOCK TABLE t IN ` + initial + ` MODE`},
+			{0, `LOCK TABLE t IN ` + upgrade + ` MODE`},
+			{1, `LOCK TABLE t IN ` + upgrade + ` MODE`},
+			{0, `COMMIT`},
+			{1, `COMMIT`},
+		},
+		Fixes: []Scenario{{
+			Name:        "strongest-first",
+			Description: "both transactions take " + upgrade + " right away",
+			Steps: []Step{
+				{0, `BEGIN`},
+				{1, `BEGIN`},
+				{0, `LOCK TABLE t IN ` + upgrade + ` MODE`},
+				{1, `LOCK TABLE t IN ` + upgrade + ` MODE`},
+				{0, `COMMIT`},
+				{1, `COMMIT`},
+			},
+		}},
+	}
+}
+
+// LockMatrix is the outcome of lockUpgradeScenario by the lock mode held by
+// both sessions and the lock mode they upgrade to.
+type LockMatrix map[string]map[string]Outcome
+
+// RunLockMatrix runs lockUpgradeScenario for every pair of lock modes, an
+// executable version of the conflict table in
+// https://www.postgresql.org/docs/current/explicit-locking.html
+func RunLockMatrix(ctx context.Context, admin *sqlx.DB, dsn string) (LockMatrix, error) {
+	cells := LockMatrix{}
+	for _, initial := range LockModes {
+		cells[initial] = map[string]Outcome{}
+		for _, upgrade := range LockModes {
+			r, err := Run(ctx, admin, dsn, lockUpgradeScenario(initial, upgrade))
+			if err != nil {
+				return nil, err
+			}
+			cells[initial][upgrade] = r.Outcome
+		}
+	}
+	return cells, nil
+}
@@ -0,0 +1,208 @@
+package scenario
+
+// The locktype scenarios exercise the values of the locktype column of
+// pg_locks besides the relation and transactionid locks of row and table
+// deadlocks. userlock is unused since contrib/userlock was removed, frozenid
+// and applytransaction are internal to vacuum and logical replication.
+
+var locktypeSetup = []string{`
+	CREATE TABLE accounts (
+		id INT PRIMARY KEY,
+		balance INT NOT NULL
+	);
+	CREATE SEQUENCE shared_seq;
+	CREATE SCHEMA reports;
+	CREATE TABLE bulk (id INT, v TEXT);
+	CREATE TABLE other (id INT);
+	INSERT INTO accounts VALUES (1, 100), (2, 100);
+	INSERT INTO other VALUES (1);`,
+}
+
+func init() {
+	Register(Scenario{
+		Name: "locktype/relation-sequence",
+		Description: "nextval takes a ROW EXCLUSIVE lock on the sequence, which " +
+			"dropping it in a migration transaction waits for",
+		Setup: locktypeSetup,
+		Steps: []Step{
+			{1, `BEGIN`},
+			{1, `UPDATE accounts SET balance = balance - 10 WHERE id = 1`},
+			{0, `BEGIN`},
+			{0, `SELECT nextval('shared_seq')`},
+			{2, `SELECT nextval('shared_seq')`},
+			{1, `DROP SEQUENCE shared_seq`},
+			{0, `UPDATE accounts SET balance = balance + 10 WHERE id = 1`},
+			{0, `COMMIT`},
+			{1, `ROLLBACK`},
+		},
+	})
+
+	Register(Scenario{
+		Name: "locktype/tuple",
+		Description: "the first of several sessions waiting for a row holds a " +
+			"tuple lock, the next ones wait on it instead of the transaction",
+		Setup: locktypeSetup,
+		Steps: []Step{
+			{0, `BEGIN`},
+			{0, `UPDATE accounts SET balance = balance - 10 WHERE id = 1`},
+			{2, `BEGIN`},
+			{2, `UPDATE accounts SET balance = balance - 10 WHERE id = 2`},
+			{1, `UPDATE accounts SET balance = balance + 10 WHERE id = 1`},
+			{2, `UPDATE accounts SET balance = balance + 10 WHERE id = 1`},
+			{0, `UPDATE accounts SET balance = balance + 10 WHERE id = 2`},
+			{0, `COMMIT`},
+			{2, `COMMIT`},
+		},
+	})
+
+	Register(Scenario{
+		Name:        "locktype/transactionid",
+		Description: "row locks are waited for with a ShareLock on the transaction holding them",
+		Setup:       locktypeSetup,
+		Steps: []Step{
+			{0, `BEGIN`},
+			{1, `BEGIN`},
+			{0, `UPDATE accounts SET balance = balance - 10 WHERE id = 1`},
+			{1, `UPDATE accounts SET balance = balance - 10 WHERE id = 2`},
+			{0, `UPDATE accounts SET balance = balance + 10 WHERE id = 2`},
+			{1, `UPDATE accounts SET balance = balance + 10 WHERE id = 1`},
+			{0, `COMMIT`},
+			{1, `COMMIT`},
+		},
+		Fixes: []Scenario{{
+			Name:        "lock-ordering",
+			Description: "both transactions update the accounts in id order",
+			Steps: []Step{
+				{0, `BEGIN`},
+				{1, `BEGIN`},
+				{0, `UPDATE accounts SET balance = balance - 10 WHERE id = 1`},
+				{1, `UPDATE accounts SET balance = balance + 10 WHERE id = 1`},
+				{0, `UPDATE accounts SET balance = balance + 10 WHERE id = 2`},
+				{1, `UPDATE accounts SET balance = balance - 10 WHERE id = 2`},
+				{0, `COMMIT`},
+				{1, `COMMIT`},
+			},
+		}},
+	})
+
+	Register(Scenario{
+		Name: "locktype/virtualxid",
+		Description: "CREATE INDEX CONCURRENTLY waits on the virtual transaction " +
+			"of an old snapshot, which then waits for the index build",
+		Setup: locktypeSetup,
+		Steps: []Step{
+			{1, `BEGIN ISOLATION LEVEL REPEATABLE READ`},
+			{1, `SELECT count(*) FROM other`},
+			{0, `CREATE INDEX CONCURRENTLY ON accounts (balance)`},
+			{1, `LOCK TABLE accounts IN SHARE UPDATE EXCLUSIVE MODE`},
+			{1, `COMMIT`},
+		},
+	})
+
+	Register(Scenario{
+		Name: "locktype/object",
+		Description: "creating a table in a schema locks the schema object, " +
+			"which DROP SCHEMA waits for",
+		Setup: locktypeSetup,
+		Steps: []Step{
+			{0, `BEGIN`},
+			{0, `CREATE TABLE reports.daily (id INT)`},
+			{1, `BEGIN`},
+			{1, `UPDATE accounts SET balance = balance - 10 WHERE id = 1`},
+			{1, `DROP SCHEMA reports CASCADE`},
+			{0, `UPDATE accounts SET balance = balance + 10 WHERE id = 1`},
+			{0, `COMMIT`},
+			{1, `COMMIT`},
+		},
+	})
+
+	Register(Scenario{
+		Name: "locktype/object-notify",
+		Description: "NOTIFY takes a cluster wide lock on object 0 of class " +
+			"pg_database while committing, it is only held during the commit",
+		Setup: locktypeSetup,
+		Steps: []Step{
+			{0, `BEGIN`},
+			{1, `BEGIN`},
+			{0, `SELECT pg_notify('accounts', g::text) FROM generate_series(1, 1000) g`},
+			{1, `NOTIFY accounts`},
+			{0, `COMMIT`},
+			{1, `COMMIT`},
+		},
+	})
+
+	Register(Scenario{
+		Name:        "locktype/advisory",
+		Description: "transaction level advisory locks taken in opposite order",
+		Setup:       locktypeSetup,
+		Steps: []Step{
+			{0, `BEGIN`},
+			{1, `BEGIN`},
+			{0, `SELECT pg_advisory_xact_lock(1)`},
+			{1, `SELECT pg_advisory_xact_lock(2)`},
+			{0, `SELECT pg_advisory_xact_lock(2)`},
+			{1, `SELECT pg_advisory_xact_lock(1)`},
+			{0, `COMMIT`},
+			{1, `COMMIT`},
+		},
+		Fixes: []Scenario{
+			{
+				Name:        "lock-ordering",
+				Description: "both transactions take the advisory locks in key order",
+				Steps: []Step{
+					{0, `BEGIN`},
+					{1, `BEGIN`},
+					{0, `SELECT pg_advisory_xact_lock(1)`},
+					{1, `SELECT pg_advisory_xact_lock(1)`},
+					{0, `SELECT pg_advisory_xact_lock(2)`},
+					{1, `SELECT pg_advisory_xact_lock(2)`},
+					{0, `COMMIT`},
+					{1, `COMMIT`},
+				},
+			},
+			{
+				Name:        "try-lock",
+				Description: "the second lock is only tried, the transaction gives up instead of waiting",
+				Steps: []Step{
+					{0, `BEGIN`},
+					{1, `BEGIN`},
+					{0, `SELECT pg_advisory_xact_lock(1)`},
+					{1, `SELECT pg_advisory_xact_lock(2)`},
+					{0, `SELECT pg_try_advisory_xact_lock(2)`},
+					{1, `SELECT pg_try_advisory_xact_lock(1)`},
+					{0, `ROLLBACK`},
+					{1, `ROLLBACK`},
+				},
+			},
+		},
+	})
+
+	Register(Scenario{
+		Name: "locktype/page",
+		Description: "before 10 a hash index scan holds a page lock on its " +
+			"bucket until the cursor is closed",
+		MaxVersion: version10,
+		Setup: append(append([]string{}, locktypeSetup...),
+			`CREATE INDEX accounts_hash ON accounts USING hash (id)`),
+		Steps: []Step{
+			{0, `BEGIN`},
+			{0, `SET LOCAL enable_seqscan = off`},
+			{0, `DECLARE c CURSOR FOR SELECT * FROM accounts WHERE id = 1`},
+			{0, `FETCH 1 FROM c`},
+			{1, `INSERT INTO accounts VALUES (3, 100)`},
+			{0, `CLOSE c`},
+			{0, `COMMIT`},
+		},
+	})
+
+	Register(Scenario{
+		Name: "locktype/extend",
+		Description: "concurrent bulk inserts wait for each other's relation " +
+			"extension lock, it is held briefly and only sampled under contention",
+		Setup: locktypeSetup,
+		Steps: []Step{
+			{0, `INSERT INTO bulk SELECT g, repeat('x', 100) FROM generate_series(1, 200000) g`},
+			{1, `INSERT INTO bulk SELECT g, repeat('x', 100) FROM generate_series(1, 200000) g`},
+		},
+	})
+}
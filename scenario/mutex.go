@@ -0,0 +1,89 @@
+package scenario
+
+import (
+	"context"
+	"time"
+
+	"github.com/13rac1/pg-deadlocks/lockmon"
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	Register(Scenario{
+		Name: "hybrid/mutex-row-lock",
+		Description: "goroutine A holds a sync.Mutex and waits for a row lock, " +
+			"goroutine B holds the row lock and waits for the mutex",
+		Setup: []string{`
+		CREATE TABLE accounts (
+			id INT PRIMARY KEY,
+			balance INT NOT NULL
+		);
+		INSERT INTO accounts VALUES (1, 100);`,
+		},
+		Run: runMutexRowLock,
+	})
+}
+
+func runMutexRowLock(ctx context.Context, db *sqlx.DB) (Result, error) {
+	a, pidA, err := OpenSession(ctx, db)
+	if err != nil {
+		return Result{}, err
+	}
+	defer a.Close()
+	b, pidB, err := OpenSession(ctx, db)
+	if err != nil {
+		return Result{}, err
+	}
+	defer b.Close()
+	defer b.ExecContext(context.Background(), `ROLLBACK`)
+	defer a.ExecContext(context.Background(), `ROLLBACK`)
+
+	mu := lockmon.NewTrackedMutex("accounts")
+	mu.Lock(pidA)
+	results := []StepResult{
+		ExecStep(ctx, b, 1, `BEGIN`),
+		ExecStep(ctx, b, 1, `UPDATE accounts SET balance = balance - 10 WHERE id = 1`),
+		ExecStep(ctx, a, 0, `BEGIN`),
+	}
+
+	ctxA, cancelA := context.WithCancel(ctx)
+	defer cancelA()
+	doneA := make(chan StepResult, 1)
+	go func() {
+		r := ExecStep(ctxA, a, 0, `UPDATE accounts SET balance = balance + 10 WHERE id = 1`)
+		mu.Unlock()
+		doneA <- r
+	}()
+	doneB := make(chan StepResult, 1)
+	go func() {
+		mu.Lock(pidB)
+		r := ExecStep(ctx, b, 1, `COMMIT`)
+		mu.Unlock()
+		doneB <- r
+	}()
+
+	r := Result{PIDs: map[int]int{0: pidA, 1: pidB}}
+	sessions := []map[int]int{{pidA: 0, pidB: 1}}
+	deadline := time.Now().Add(BlockTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(DeadlockTimeout)
+		g, err := lockmon.SampleLockGraph(ctx, db)
+		if err != nil {
+			return Result{}, err
+		}
+		g.Edges = append(g.Edges, mu.Edges()...)
+		if g.FindCycle() != nil {
+			merged := lockmon.MergeGraphs([]string{"hybrid"}, []lockmon.LockGraph{g}, sessions)
+			r.Graph = &merged
+			r.Outcome = OutcomeAppDeadlock
+			break
+		}
+	}
+	// Break the cycle by cancelling the row lock wait of goroutine A.
+	cancelA()
+	rA := <-doneA
+	rA.Blocked = r.Outcome == OutcomeAppDeadlock
+	rB := <-doneB
+	r.Steps = append(results, rA, rB)
+	return r, nil
+}
@@ -0,0 +1,103 @@
+package scenario
+
+const (
+	version10 = 100000
+	version11 = 110000
+	version14 = 140000
+)
+
+// partitionSetup has a partitioned table with partition p1 attached and
+// table p2 which can be attached. events closes the lock cycles, its primary
+// key is not on the partitioned table as unique indexes require 11.
+var partitionSetup = []string{`
+	CREATE TABLE measurements (
+		id INT NOT NULL,
+		v TEXT
+	) PARTITION BY RANGE (id);
+	CREATE TABLE p1 PARTITION OF measurements FOR VALUES FROM (0) TO (100);
+	CREATE TABLE p2 (
+		id INT NOT NULL,
+		v TEXT
+	);
+	CREATE TABLE events (id INT PRIMARY KEY);
+	INSERT INTO measurements VALUES (1, 'one');`,
+}
+
+// partitionedSetup has both partitions attached.
+var partitionedSetup = append(append([]string{}, partitionSetup...),
+	`ALTER TABLE measurements ATTACH PARTITION p2 FOR VALUES FROM (100) TO (200);
+	INSERT INTO measurements VALUES (101, 'one hundred one');`)
+
+func init() {
+	Register(Scenario{
+		Name: "partition/attach/in-tx",
+		Description: "ATTACH PARTITION after an INSERT in the same transaction, " +
+			"while another transaction reads the table being attached",
+		MinVersion: version10,
+		Setup:      partitionSetup,
+		Steps: []Step{
+			{1, `BEGIN`},
+			{1, `SELECT count(*) FROM p2`},
+			{0, `BEGIN`},
+			{0, `INSERT INTO events VALUES (1)`},
+			{0, `ALTER TABLE measurements ATTACH PARTITION p2 FOR VALUES FROM (100) TO (200)`},
+			// Conflicts with the INSERT of event 1
+			{1, `INSERT INTO events VALUES (1)`},
+			{1, `COMMIT`},
+			{0, `COMMIT`},
+		},
+	})
+
+	Register(Scenario{
+		Name: "partition/detach/in-tx",
+		Description: "DETACH PARTITION after an INSERT in the same transaction, " +
+			"while another transaction inserts rows routed to the partition",
+		MinVersion: version10,
+		Setup:      partitionSetup,
+		Steps: []Step{
+			{1, `BEGIN`},
+			{1, `INSERT INTO measurements VALUES (2, 'two')`},
+			{0, `BEGIN`},
+			{0, `INSERT INTO events VALUES (1)`},
+			{0, `ALTER TABLE measurements DETACH PARTITION p1`},
+			// Conflicts with the INSERT of event 1
+			{1, `INSERT INTO events VALUES (1)`},
+			{1, `COMMIT`},
+			{0, `COMMIT`},
+		},
+	})
+
+	Register(Scenario{
+		Name: "partition/detach-concurrently",
+		Description: "DETACH PARTITION CONCURRENTLY waits for transactions using " +
+			"the partition without blocking new inserts into the other partition",
+		MinVersion: version14,
+		Setup:      partitionedSetup,
+		Steps: []Step{
+			{1, `BEGIN`},
+			{1, `INSERT INTO measurements VALUES (2, 'two')`},
+			{0, `ALTER TABLE measurements DETACH PARTITION p1 CONCURRENTLY`},
+			{2, `INSERT INTO measurements VALUES (102, 'one hundred two')`},
+			{1, `COMMIT`},
+		},
+	})
+
+	Register(Scenario{
+		Name: "partition/cross-partition-update",
+		Description: "UPDATEs moving rows between partitions, a moved row is " +
+			"deleted from one partition and inserted into the other",
+		MinVersion: version11,
+		Setup:      partitionedSetup,
+		Steps: []Step{
+			{0, `BEGIN`},
+			{1, `BEGIN`},
+			// Moves row 1 from p1 to p2 and row 101 from p2 to p1.
+			{0, `UPDATE measurements SET id = 150 WHERE id = 1`},
+			{1, `UPDATE measurements SET id = 50 WHERE id = 101`},
+			{0, `UPDATE measurements SET v = 'updated' WHERE id = 101`},
+			{1, `UPDATE measurements SET v = 'updated' WHERE id = 1`},
+			{0, `COMMIT`},
+			{1, `COMMIT`},
+		},
+	})
+}
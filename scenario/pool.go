@@ -0,0 +1,77 @@
+package scenario
+
+import (
+	"context"
+	"time"
+
+	"github.com/13rac1/pg-deadlocks/lockmon"
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	Register(Scenario{
+		Name: "pool/exhaustion",
+		Description: "with one pooled connection, a query issued on the pool instead " +
+			"of the open transaction waits forever for the connection the transaction holds",
+		Setup: []string{`
+		CREATE TABLE accounts (
+			id INT PRIMARY KEY,
+			balance INT NOT NULL
+		);
+		INSERT INTO accounts VALUES (1, 100), (2, 100);`,
+		},
+		Run: runPoolExhaustion,
+	})
+}
+
+func runPoolExhaustion(ctx context.Context, db *sqlx.DB) (Result, error) {
+	db.SetMaxOpenConns(1)
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	defer tx.Rollback()
+
+	results := []StepResult{
+		ExecStep(ctx, tx, 0, `UPDATE accounts SET balance = balance - 10 WHERE id = 1`),
+	}
+
+	// The bug: db instead of tx. Session 1 is the pool, it never gets a
+	// connection. The stats are taken before the query waits, the watchdog
+	// looks for waits since then.
+	start := db.Stats()
+	queryCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan StepResult)
+	go func() {
+		done <- ExecStep(queryCtx, db, 1, `UPDATE accounts SET balance = balance + 10 WHERE id = 2`)
+	}()
+
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	defer stopWatch()
+	timeout := time.NewTimer(BlockTimeout)
+	defer timeout.Stop()
+	select {
+	case r := <-done:
+		return Result{Steps: append(results, r)}, nil
+	case stall := <-lockmon.WatchPool(watchCtx, db, start, 100*time.Millisecond, 5):
+		cancel()
+		r := <-done
+		r.Blocked = true
+		return Result{
+			Outcome:   OutcomeAppDeadlock,
+			Steps:     append(results, r),
+			PoolStall: &stall,
+		}, nil
+	case <-timeout.C:
+		// Blocked without the watchdog noticing a stall.
+		cancel()
+		r := <-done
+		r.Blocked = true
+		return Result{Steps: append(results, r)}, nil
+	case <-ctx.Done():
+		cancel()
+		<-done
+		return Result{}, ctx.Err()
+	}
+}
@@ -0,0 +1,70 @@
+package scenario
+
+// claimJob takes the oldest new job of the queue. queueSteps appends a locking
+// option to choose how a job locked by another worker is handled.
+const claimJob = `SELECT id FROM jobs WHERE status = 'new' ORDER BY id LIMIT 1 FOR UPDATE`
+
+const updateStatus = `UPDATE worker_status SET active = active + 1 WHERE id = 1`
+
+func init() {
+	Register(Scenario{
+		Name:        "queue/for-update",
+		Description: "workers claiming jobs and updating a shared status row in opposite order",
+		Setup: []string{`
+		CREATE TABLE jobs (
+			id SERIAL PRIMARY KEY,
+			status TEXT NOT NULL DEFAULT 'new'
+		);
+		CREATE TABLE worker_status (
+			id INT PRIMARY KEY,
+			active INT NOT NULL
+		);
+		INSERT INTO jobs (status) VALUES ('new'), ('new'), ('new');
+		INSERT INTO worker_status VALUES (1, 0);`,
+		},
+		Steps: queueSteps(""),
+		Fixes: []Scenario{
+			{
+				Name:        "skip-locked",
+				Aliases:     []string{"queue/skip-locked"},
+				Description: "the second worker skips the claimed job and only waits for the status row",
+				Steps:       queueSteps(" SKIP LOCKED"),
+			},
+			{
+				Name:        "nowait",
+				Aliases:     []string{"queue/nowait"},
+				Description: "the second worker fails with lock_not_available instead of waiting",
+				Steps:       queueSteps(" NOWAIT"),
+			},
+			{
+				Name:        "lock-ordering",
+				Description: "both workers update the status row before claiming a job",
+				Steps: []Step{
+					{0, `BEGIN`},
+					{0, updateStatus},
+					{0, claimJob},
+					{1, `BEGIN`},
+					{1, updateStatus},
+					{1, claimJob},
+					{0, `COMMIT`},
+					{1, `COMMIT`},
+				},
+			},
+		},
+	})
+}
+
+func queueSteps(lock string) []Step {
+	return []Step{
+		// Worker 0 claims a job and then records itself as active.
+		{0, `BEGIN`},
+		{0, claimJob + lock},
+		// Worker 1 records itself as active and then claims a job.
+		{1, `BEGIN`},
+		{1, updateStatus},
+		{1, claimJob + lock},
+		{0, updateStatus},
+		{1, `COMMIT`},
+		{0, `COMMIT`},
+	}
+}
@@ -0,0 +1,137 @@
+package scenario
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/13rac1/pg-deadlocks/txretry"
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	Register(Scenario{
+		Name: "retry/deadlock",
+		Description: "transfers updating rows in opposite order deadlock on the " +
+			"first attempt, txretry.RunInTx retries the victim until both commit",
+		Setup: isolationSetup,
+		Run:   runRetryDeadlock,
+	})
+
+	Register(Scenario{
+		Name: "retry/serialization-failure",
+		Description: "a REPEATABLE READ transaction updating a row changed after " +
+			"its snapshot is retried by txretry.RunInTx with a new snapshot",
+		Setup: isolationSetup,
+		Run:   runRetrySerializationFailure,
+	})
+}
+
+// retried runs fn with txretry.RunInTx and records it as a step, with the
+// number of attempts and the error codes which were retried.
+func retried(ctx context.Context, db *sqlx.DB, session int, name string, txOptions *sql.TxOptions, fn func(ctx context.Context, tx *sqlx.Tx, attempt int) error) StepResult {
+	attempts := 0
+	var codes []string
+	opts := txretry.Options{
+		TxOptions: txOptions,
+		OnAttempt: func(a txretry.Attempt) {
+			attempts = a.Number
+			if a.Retry {
+				codes = append(codes, string(ErrorCode(a.Err)))
+			}
+		},
+	}
+	start := time.Now()
+	err := txretry.RunInTx(ctx, db, opts, func(ctx context.Context, tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL deadlock_timeout = %d", DeadlockTimeout.Milliseconds()))
+		if err != nil {
+			return err
+		}
+		return fn(ctx, tx, attempts+1)
+	})
+	description := fmt.Sprintf("RunInTx %s: %d attempts", name, attempts)
+	if len(codes) > 0 {
+		description += ", retried " + strings.Join(codes, ",")
+	}
+	return StepResult{
+		Step:     Step{session, description},
+		Err:      err,
+		Duration: time.Since(start),
+	}
+}
+
+func runRetryDeadlock(ctx context.Context, db *sqlx.DB) (Result, error) {
+	// Both transactions hold their first row lock before either takes the
+	// second, only on the first attempt.
+	var locked sync.WaitGroup
+	locked.Add(2)
+	transfer := func(session, from, to int) StepResult {
+		name := fmt.Sprintf("transfer %d to %d", from, to)
+		return retried(ctx, db, session, name, nil, func(ctx context.Context, tx *sqlx.Tx, attempt int) error {
+			_, err := tx.ExecContext(ctx, `UPDATE accounts SET balance = balance - 10 WHERE id = $1`, from)
+			if attempt == 1 {
+				locked.Done()
+				locked.Wait()
+			}
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `UPDATE accounts SET balance = balance + 10 WHERE id = $1`, to)
+			return err
+		})
+	}
+
+	results := make([]StepResult, 2)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		results[0] = transfer(0, 1, 2)
+	}()
+	go func() {
+		defer wg.Done()
+		results[1] = transfer(1, 2, 1)
+	}()
+	wg.Wait()
+	return Result{Steps: results}, nil
+}
+
+func runRetrySerializationFailure(ctx context.Context, db *sqlx.DB) (Result, error) {
+	read := make(chan struct{})
+	updated := make(chan struct{})
+	results := make([]StepResult, 2)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		txOptions := &sql.TxOptions{Isolation: sql.LevelRepeatableRead}
+		results[0] = retried(ctx, db, 0, "deposit", txOptions, func(ctx context.Context, tx *sqlx.Tx, attempt int) error {
+			var balance int
+			err := tx.GetContext(ctx, &balance, `SELECT balance FROM accounts WHERE id = 1`)
+			if attempt == 1 {
+				// The snapshot is taken, let the other transaction commit.
+				close(read)
+				<-updated
+			}
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `UPDATE accounts SET balance = $1 WHERE id = 1`, balance+10)
+			return err
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		<-read
+		results[1] = retried(ctx, db, 1, "withdrawal", nil, func(ctx context.Context, tx *sqlx.Tx, attempt int) error {
+			_, err := tx.ExecContext(ctx, `UPDATE accounts SET balance = balance - 10 WHERE id = 1`)
+			return err
+		})
+		close(updated)
+	}()
+	wg.Wait()
+	return Result{Steps: results}, nil
+}
@@ -0,0 +1,71 @@
+package scenario
+
+var savepointSetup = []string{`
+	CREATE TABLE accounts (
+		id INT PRIMARY KEY,
+		balance INT NOT NULL
+	);
+	INSERT INTO accounts VALUES (1, 100), (2, 100);`,
+}
+
+func init() {
+	Register(Scenario{
+		Name: "savepoint/subtransaction",
+		Description: "both row locks are taken inside savepoints, the deadlock is " +
+			"between subtransaction xids of the two sessions",
+		Setup: savepointSetup,
+		Steps: []Step{
+			{0, `BEGIN`},
+			{0, `SAVEPOINT a`},
+			{0, `UPDATE accounts SET balance = balance - 10 WHERE id = 1`},
+			{1, `BEGIN`},
+			{1, `SAVEPOINT a`},
+			{1, `UPDATE accounts SET balance = balance - 10 WHERE id = 2`},
+			{0, `SAVEPOINT b`},
+			{0, `UPDATE accounts SET balance = balance + 10 WHERE id = 2`},
+			{1, `SAVEPOINT b`},
+			{1, `UPDATE accounts SET balance = balance + 10 WHERE id = 1`},
+			{1, `ROLLBACK`},
+			{0, `COMMIT`},
+		},
+	})
+
+	Register(Scenario{
+		Name: "savepoint/retry-keeps-locks",
+		Description: "retrying the deadlocked statement after ROLLBACK TO SAVEPOINT " +
+			"deadlocks again, the row locked before the savepoint is still held",
+		Setup: savepointSetup,
+		Steps: []Step{
+			{0, `BEGIN`},
+			{0, `UPDATE accounts SET balance = balance - 10 WHERE id = 1`},
+			{1, `BEGIN`},
+			{1, `UPDATE accounts SET balance = balance - 10 WHERE id = 2`},
+			{0, `SAVEPOINT retry`},
+			{0, `UPDATE accounts SET balance = balance + 10 WHERE id = 2`},
+			// An ORM retrying the failed statement in a savepoint.
+			{1, `SAVEPOINT retry`},
+			{1, `UPDATE accounts SET balance = balance + 10 WHERE id = 1`},
+			{1, `ROLLBACK TO SAVEPOINT retry`},
+			{1, `UPDATE accounts SET balance = balance + 10 WHERE id = 1`},
+			{1, `ROLLBACK`},
+			{0, `COMMIT`},
+		},
+	})
+
+	Register(Scenario{
+		Name: "savepoint/rollback-releases",
+		Description: "ROLLBACK TO SAVEPOINT releases the row lock taken inside the " +
+			"savepoint and the waiting session continues",
+		Setup: savepointSetup,
+		Steps: []Step{
+			{0, `BEGIN`},
+			{0, `UPDATE accounts SET balance = balance - 10 WHERE id = 1`},
+			{0, `SAVEPOINT a`},
+			{0, `SELECT * FROM accounts WHERE id = 2 FOR UPDATE`},
+			{1, `UPDATE accounts SET balance = balance - 10 WHERE id = 2`},
+			{0, `ROLLBACK TO SAVEPOINT a`},
+			{1, `UPDATE accounts SET balance = balance - 10 WHERE id = 1`},
+			{0, `COMMIT`},
+		},
+	})
+}
@@ -0,0 +1,582 @@
+// Package scenario runs reproducible sets of concurrent statements against a
+// Postgres server and classifies how they ended, e.g. in a deadlock. The
+// bundled scenarios are registered on import, application test suites can
+// run their own against their schema.
+package scenario
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/13rac1/pg-deadlocks/lockmon"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	// DeadlockTimeout is set on every scenario session so Postgres runs the
+	// deadlock detector quickly instead of after the default one second.
+	DeadlockTimeout = 100 * time.Millisecond
+	// StepWait is how long a step may run before the next step is started
+	// anyway. It must be longer than DeadlockTimeout so a deadlock closed by
+	// a step is reported before moving on.
+	StepWait = 300 * time.Millisecond
+	// BlockTimeout is how long to wait for outstanding steps once every step
+	// has been started. Steps still running after it are cancelled.
+	BlockTimeout = 2 * time.Second
+
+	scenarios []Scenario
+	// databaseID numbers the databases of OpenDB. The prefix is unique to
+	// the process, so test processes sharing a server do not collide.
+	databaseID     int64
+	databasePrefix = fmt.Sprintf("scenario_%d_%x", os.Getpid(), time.Now().UnixNano()&0xffffff)
+)
+
+// DatabaseVar is replaced with the name of the database of the run in the
+// steps and cleanup statements of a scenario.
+const DatabaseVar = "{database}"
+
+// Step is a single SQL statement executed by one session of a scenario.
+// Sessions are separate connections in autocommit mode, so transactions are
+// started with an explicit BEGIN step.
+type Step struct {
+	Session int    `json:"session"`
+	SQL     string `json:"sql"`
+}
+
+// Scenario is a reproducible set of concurrent statements. Setup runs in a
+// fresh database before the steps are started in order, Cleanup runs after
+// every session has been closed, also when the run fails. DatabaseVar in
+// Steps and Cleanup is replaced with the name of the database of the run,
+// to name objects shared by the whole server, like prepared transactions.
+type Scenario struct {
+	Name        string
+	Description string
+	Setup       []string
+	Steps       []Step
+	Cleanup     []string
+	// MinVersion is the oldest server_version_num the scenario runs on, it is
+	// skipped on older servers. MaxVersion, when set, is the first version it
+	// no longer runs on.
+	MinVersion int
+	MaxVersion int
+	// Run replaces Steps for scenarios which need Go code between statements.
+	// It is called with the scenario database after Setup, the name and
+	// duration of the result are filled in by the caller.
+	Run func(ctx context.Context, db *sqlx.DB) (Result, error)
+	// Fixes are variants of the scenario with a mitigation applied, e.g.
+	// consistent lock ordering or NOWAIT. Their name is appended to the name
+	// of the scenario after a "+", they inherit Setup and the versions when
+	// unset.
+	Fixes []Scenario
+	// Aliases are earlier names of the scenario. They only select it when
+	// given in full, patterns match Name so that a scenario is listed once.
+	Aliases []string
+}
+
+// StepResult records what happened to a step once the scenario finished.
+type StepResult struct {
+	Step
+	Err      error
+	Duration time.Duration
+	// Waited is set when the step did not complete within StepWait.
+	Waited bool
+	// Blocked is set when the step never completed and was cancelled.
+	Blocked bool
+	// Locks are the locks the session was granted by this step.
+	Locks []lockmon.HeldLock
+	// Graph is the wait-for graph sampled while the step was waiting.
+	Graph *lockmon.LockGraph
+}
+
+// Outcome classifies how a scenario or one of its steps ended.
+type Outcome int
+
+// OutcomeSkipped is the outcome of a scenario not supported by the server.
+const OutcomeSkipped Outcome = -1
+
+// Outcomes are ordered by severity, the most severe outcome of any step is
+// the outcome of the run.
+const (
+	OutcomeOK Outcome = iota
+	OutcomeWaited
+	OutcomeLockNotAvailable
+	OutcomeSerializationFailure
+	OutcomeError
+	OutcomeBlocked
+	OutcomeDeadlock
+	// OutcomeAppDeadlock is a deadlock in the application, which Postgres
+	// cannot detect.
+	OutcomeAppDeadlock
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeSkipped:
+		return "skipped"
+	case OutcomeOK:
+		return "ok"
+	case OutcomeWaited:
+		return "waited"
+	case OutcomeLockNotAvailable:
+		return "lock-not-available"
+	case OutcomeSerializationFailure:
+		return "serialization-failure"
+	case OutcomeError:
+		return "error"
+	case OutcomeBlocked:
+		return "blocked"
+	case OutcomeDeadlock:
+		return "deadlock"
+	case OutcomeAppDeadlock:
+		return "application-deadlock"
+	}
+	return fmt.Sprintf("outcome(%d)", int(o))
+}
+
+// Result is the result of a run of a scenario.
+type Result struct {
+	Scenario string
+	Outcome  Outcome
+	Steps    []StepResult
+	Duration time.Duration
+	// PIDs maps the sessions to their backend pid.
+	PIDs map[int]int
+	// Graph is a wait-for graph between sessions in which a cycle was found
+	// on the client side, for deadlocks Postgres cannot detect. Its nodes are
+	// session numbers instead of pids.
+	Graph *lockmon.LockGraph
+	// PoolStall is set when the connection pool of the application stalled.
+	PoolStall *lockmon.PoolStall
+	// ServerVersion is the server_version_num of the server run on.
+	ServerVersion int
+}
+
+// SessionName names a backend by its session, pid 0 are the locks held by
+// prepared transactions.
+func (r Result) SessionName(pid int) string {
+	if pid == 0 {
+		return "prepared transaction"
+	}
+	for id, p := range r.PIDs {
+		if p == pid {
+			return fmt.Sprintf("s%d", id)
+		}
+	}
+	return fmt.Sprintf("pid %d", pid)
+}
+
+// Register adds a scenario and its fixes to the scenarios returned by All and
+// Match.
+func Register(s Scenario) {
+	for i := range s.Fixes {
+		f := &s.Fixes[i]
+		f.Name = s.Name + "+" + f.Name
+		if f.Setup == nil {
+			f.Setup = s.Setup
+		}
+		if f.MinVersion == 0 {
+			f.MinVersion = s.MinVersion
+		}
+		if f.MaxVersion == 0 {
+			f.MaxVersion = s.MaxVersion
+		}
+	}
+	scenarios = append(scenarios, s)
+}
+
+// All returns the registered scenarios, without their fixes.
+func All() []Scenario {
+	return scenarios
+}
+
+// InsertSteps returns a copy of steps with extra inserted before steps[i].
+func InsertSteps(steps []Step, i int, extra ...Step) []Step {
+	inserted := append(append([]Step{}, steps[:i]...), extra...)
+	return append(inserted, steps[i:]...)
+}
+
+// Match returns the scenarios and fixes whose name matches pattern,
+// e.g. "ddl/*/in-tx", see path.Match for the syntax.
+func Match(pattern string) ([]Scenario, error) {
+	var matches []Scenario
+	for _, s := range scenarios {
+		for _, v := range append([]Scenario{s}, s.Fixes...) {
+			ok, err := path.Match(pattern, v.Name)
+			if err != nil {
+				return nil, err
+			}
+			if ok || v.hasAlias(pattern) {
+				matches = append(matches, v)
+			}
+		}
+	}
+	return matches, nil
+}
+
+// Lookup returns the scenario or fix named name, or one of its aliases.
+func Lookup(name string) (Scenario, bool) {
+	for _, s := range scenarios {
+		for _, v := range append([]Scenario{s}, s.Fixes...) {
+			if v.Name == name || v.hasAlias(name) {
+				return v, true
+			}
+		}
+	}
+	return Scenario{}, false
+}
+
+func (s Scenario) hasAlias(name string) bool {
+	for _, alias := range s.Aliases {
+		if alias == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrorCode returns the Postgres error code of err, or "" if err did not come
+// from the server.
+func ErrorCode(err error) pq.ErrorCode {
+	var errPq *pq.Error
+	if errors.As(err, &errPq) {
+		return errPq.Code
+	}
+	return ""
+}
+
+// Outcome classifies how the step ended.
+func (r StepResult) Outcome() Outcome {
+	switch {
+	case ErrorCode(r.Err) == "40P01":
+		return OutcomeDeadlock
+	case r.Blocked:
+		return OutcomeBlocked
+	case ErrorCode(r.Err) == "55P03":
+		// NOWAIT and lock_timeout fail instead of waiting.
+		return OutcomeLockNotAvailable
+	case ErrorCode(r.Err) == "40001":
+		// Unlike a deadlock there is no lock cycle, the transaction read data
+		// which a concurrent transaction changed.
+		return OutcomeSerializationFailure
+	case r.Err != nil:
+		return OutcomeError
+	case r.Waited:
+		return OutcomeWaited
+	}
+	return OutcomeOK
+}
+
+// Classify returns the most severe outcome of the steps.
+func Classify(results []StepResult) Outcome {
+	o := OutcomeOK
+	for _, r := range results {
+		if so := r.Outcome(); so > o {
+			o = so
+		}
+	}
+	return o
+}
+
+// Run creates a fresh database, runs the scenario setup and steps in
+// it and drops the database again. admin is connected to the server with
+// dsn, see OpenDB.
+func Run(ctx context.Context, admin *sqlx.DB, dsn string, s Scenario) (result Result, err error) {
+	version, err := lockmon.ServerVersion(ctx, admin)
+	if err != nil {
+		return Result{}, err
+	}
+	if version < s.MinVersion || (s.MaxVersion > 0 && version >= s.MaxVersion) {
+		return Result{Scenario: s.Name, Outcome: OutcomeSkipped, ServerVersion: version}, nil
+	}
+
+	db, closeDB, err := OpenDB(ctx, admin, dsn, s.Setup)
+	if err != nil {
+		return Result{}, err
+	}
+	defer func() {
+		if closeErr := closeDB(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	var name string
+	err = db.GetContext(ctx, &name, "SELECT current_database()")
+	if err != nil {
+		return Result{}, err
+	}
+	vars := strings.NewReplacer(DatabaseVar, name)
+	// The cleanup runs on every path, a prepared transaction left behind
+	// keeps its locks and prevents dropping the database.
+	defer func() {
+		for _, query := range s.Cleanup {
+			query = vars.Replace(query)
+			_, cleanupErr := db.ExecContext(ctx, query)
+			if cleanupErr != nil && err == nil {
+				result, err = Result{}, fmt.Errorf("cleanup %q: %w", query, cleanupErr)
+			}
+		}
+	}()
+
+	start := time.Now()
+	var r Result
+	if s.Run != nil {
+		r, err = s.Run(ctx, db)
+	} else {
+		steps := make([]Step, len(s.Steps))
+		for i, step := range s.Steps {
+			steps[i] = Step{step.Session, vars.Replace(step.SQL)}
+		}
+		r.Steps, r.PIDs, err = RunSteps(ctx, db, steps)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	r.Scenario = s.Name
+	r.Duration = time.Since(start)
+	r.ServerVersion = version
+	if c := Classify(r.Steps); c > r.Outcome {
+		r.Outcome = c
+	}
+	return r, nil
+}
+
+// OpenDB creates a fresh database on the server admin is connected to and
+// runs setup in it. dsn is the connection string of admin, a URL or key=value
+// pairs, the fresh database is connected to with its database replaced. The
+// returned func closes the connection pool and drops the database.
+func OpenDB(ctx context.Context, admin *sqlx.DB, dsn string, setup []string) (*sqlx.DB, func() error, error) {
+	name := fmt.Sprintf("%s_%d", databasePrefix, atomic.AddInt64(&databaseID, 1))
+	dbDSN, err := DatabaseDSN(dsn, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = admin.ExecContext(ctx, "CREATE DATABASE "+name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create database: %w", err)
+	}
+
+	db, err := sqlx.Connect("postgres", dbDSN)
+	if err != nil {
+		dropDatabase(ctx, admin, name)
+		return nil, nil, fmt.Errorf("unable to connect to %s: %w", name, err)
+	}
+	closeDB := func() error {
+		db.Close()
+		return dropDatabase(ctx, admin, name)
+	}
+
+	for _, query := range setup {
+		_, err = db.ExecContext(ctx, query)
+		if err != nil {
+			closeDB()
+			return nil, nil, fmt.Errorf("setup %q: %w", query, err)
+		}
+	}
+	return db, closeDB, nil
+}
+
+// DatabaseDSN returns dsn, a postgres:// URL or key=value pairs, with its
+// database replaced by name.
+func DatabaseDSN(dsn, name string) (string, error) {
+	if !strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://") {
+		// The last value of a key wins.
+		return strings.TrimSpace(dsn + " dbname=" + name), nil
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", fmt.Errorf("invalid connection URL: %w", err)
+	}
+	u.Path = "/" + name
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// ExecStep runs a statement for a Run function and records it as a step.
+func ExecStep(ctx context.Context, db sqlx.ExecerContext, session int, query string) StepResult {
+	start := time.Now()
+	_, err := db.ExecContext(ctx, query)
+	return StepResult{
+		Step:     Step{session, query},
+		Err:      err,
+		Duration: time.Since(start),
+	}
+}
+
+func dropDatabase(ctx context.Context, admin *sqlx.DB, name string) error {
+	// Backends of cancelled sessions can take a moment to exit, until then the
+	// database is still "being accessed by other users".
+	var err error
+	for i := 0; i < 10; i++ {
+		_, err = admin.ExecContext(ctx, "DROP DATABASE IF EXISTS "+name)
+		if err == nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("unable to drop database %s: %w", name, err)
+}
+
+type session struct {
+	conn   *sql.Conn
+	pid    int
+	ctx    context.Context
+	cancel context.CancelFunc
+	queue  chan int
+}
+
+// OpenSession returns a dedicated connection with a short deadlock_timeout
+// and its backend pid.
+func OpenSession(ctx context.Context, db *sqlx.DB) (*sql.Conn, int, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to open session: %w", err)
+	}
+	_, err = conn.ExecContext(ctx, fmt.Sprintf("SET deadlock_timeout = %d", DeadlockTimeout.Milliseconds()))
+	if err != nil {
+		conn.Close()
+		return nil, 0, fmt.Errorf("unable to configure session: %w", err)
+	}
+	var pid int
+	err = conn.QueryRowContext(ctx, "SELECT pg_backend_pid()").Scan(&pid)
+	if err != nil {
+		conn.Close()
+		return nil, 0, fmt.Errorf("unable to get pid of session: %w", err)
+	}
+	return conn, pid, nil
+}
+
+// RunSteps starts every step in order on its session. The next step is
+// started once the previous one completes or StepWait passes, so a blocked
+// session does not stop the other sessions from making progress. The locks
+// granted by each step are sampled from pg_locks once the step completes.
+func RunSteps(ctx context.Context, db *sqlx.DB, steps []Step) ([]StepResult, map[int]int, error) {
+	results := make([]StepResult, len(steps))
+	done := make([]chan struct{}, len(steps))
+	sessions := map[int]*session{}
+	var wg sync.WaitGroup
+
+	defer func() {
+		for _, s := range sessions {
+			s.cancel()
+			// Leave no transaction open behind a cancelled or failed step.
+			s.conn.ExecContext(context.Background(), "ROLLBACK")
+			s.conn.Close()
+		}
+	}()
+
+	for i, st := range steps {
+		results[i].Step = st
+		done[i] = make(chan struct{})
+		if _, ok := sessions[st.Session]; ok {
+			continue
+		}
+		conn, pid, err := OpenSession(ctx, db)
+		if err != nil {
+			return nil, nil, fmt.Errorf("session %d: %w", st.Session, err)
+		}
+		sctx, cancel := context.WithCancel(ctx)
+		sessions[st.Session] = &session{
+			conn:   conn,
+			pid:    pid,
+			ctx:    sctx,
+			cancel: cancel,
+			queue:  make(chan int, len(steps)),
+		}
+	}
+
+	for _, s := range sessions {
+		wg.Add(1)
+		go func(s *session) {
+			defer wg.Done()
+			var held []lockmon.HeldLock
+			for i := range s.queue {
+				start := time.Now()
+				_, err := s.conn.ExecContext(s.ctx, steps[i].SQL)
+				results[i].Err = err
+				results[i].Duration = time.Since(start)
+				locks, err := lockmon.SessionLocks(ctx, db, s.pid)
+				if err == nil {
+					results[i].Locks = lockmon.NewLocks(held, locks)
+					held = locks
+				}
+				close(done[i])
+			}
+		}(s)
+	}
+
+	waited := make([]bool, len(steps))
+	graphs := make([]*lockmon.LockGraph, len(steps))
+	for i, st := range steps {
+		sessions[st.Session].queue <- i
+		// Sample the locks before the deadlock detector can break a cycle.
+		if waitDone(done[i], DeadlockTimeout/2) {
+			continue
+		}
+		g, err := lockmon.SampleLockGraph(ctx, db)
+		if err == nil {
+			graphs[i] = &g
+		}
+		waited[i] = !waitDone(done[i], StepWait-DeadlockTimeout/2)
+	}
+
+	blocked := make([]bool, len(steps))
+	deadline := time.Now().Add(BlockTimeout)
+	for i, st := range steps {
+		if !waitDone(done[i], time.Until(deadline)) {
+			blocked[i] = true
+			sessions[st.Session].cancel()
+		}
+	}
+	for _, s := range sessions {
+		close(s.queue)
+	}
+	wg.Wait()
+
+	pids := map[int]int{}
+	for id, s := range sessions {
+		pids[id] = s.pid
+	}
+	for i := range results {
+		results[i].Waited = waited[i]
+		results[i].Blocked = blocked[i]
+		results[i].Graph = graphs[i]
+	}
+	return results, pids, nil
+}
+
+// waitDone reports whether done is closed within d.
+func waitDone(done <-chan struct{}, d time.Duration) bool {
+	select {
+	case <-done:
+		return true
+	default:
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-done:
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+// kebabCase turns SQL keywords into a scenario name, e.g. SHARE ROW EXCLUSIVE
+// to share-row-exclusive.
+func kebabCase(keywords string) string {
+	return strings.ToLower(strings.ReplaceAll(keywords, " ", "-"))
+}
+
+func oneLine(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
@@ -0,0 +1,16 @@
+package scenario
+
+import (
+	"io/ioutil"
+
+	"github.com/13rac1/pg-deadlocks/internal/sqlscan"
+)
+
+// ReadScript reads the statements of a SQL file.
+func ReadScript(name string) ([]string, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return sqlscan.SplitStatements(string(b)), nil
+}
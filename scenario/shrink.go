@@ -0,0 +1,315 @@
+package scenario
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/13rac1/pg-deadlocks/internal/sqlscan"
+)
+
+// Shrink returns the smallest scenario derived from s which still ends with
+// the outcome want, usually OutcomeDeadlock. It removes whole sessions, then
+// single steps, then setup statements and finally rows of multi row INSERTs
+// in the setup, each with delta debugging, and lowers the bounds of
+// generate_series(from, to) calls in the setup by bisection. progress is
+// called with every smaller scenario which reproduced.
+func Shrink(ctx context.Context, admin *sqlx.DB, dsn string, s Scenario, want Outcome, progress func(Scenario)) (Scenario, error) {
+	if s.Run != nil {
+		return Scenario{}, fmt.Errorf("scenario %s runs Go code and cannot be shrunk", s.Name)
+	}
+	var runErr error
+	smallest := shrink(s, func(c Scenario) bool {
+		if runErr != nil {
+			return false
+		}
+		r, err := Run(ctx, admin, dsn, c)
+		if err != nil {
+			runErr = err
+			return false
+		}
+		ok := r.Outcome == want
+		if ok && progress != nil {
+			progress(c)
+		}
+		return ok
+	})
+	if runErr != nil {
+		return Scenario{}, runErr
+	}
+	return smallest, nil
+}
+
+// shrink is Shrink with the runs of the candidates replaced by reproduces,
+// which is called once per distinct candidate.
+func shrink(s Scenario, test func(Scenario) bool) Scenario {
+	tried := map[string]bool{}
+	reproduces := func(c Scenario) bool {
+		key := fmt.Sprintf("%q %v", c.Setup, c.Steps)
+		if ok, done := tried[key]; done {
+			return ok
+		}
+		ok := test(c)
+		tried[key] = ok
+		return ok
+	}
+
+	// Sessions.
+	var sessions []int
+	seen := map[int]bool{}
+	for _, st := range s.Steps {
+		if !seen[st.Session] {
+			seen[st.Session] = true
+			sessions = append(sessions, st.Session)
+		}
+	}
+	withSessions := func(keep []int) Scenario {
+		c := s
+		c.Steps = nil
+		for _, st := range s.Steps {
+			if containsInt(keep, st.Session) {
+				c.Steps = append(c.Steps, st)
+			}
+		}
+		return c
+	}
+	s = withSessions(ddmin(sessions, func(keep []int) bool {
+		return reproduces(withSessions(keep))
+	}))
+
+	// Steps.
+	withSteps := func(keep []int) Scenario {
+		c := s
+		c.Steps = nil
+		for _, i := range keep {
+			c.Steps = append(c.Steps, s.Steps[i])
+		}
+		return c
+	}
+	s = withSteps(ddmin(indexes(len(s.Steps)), func(keep []int) bool {
+		return reproduces(withSteps(keep))
+	}))
+
+	// Setup statements.
+	var setup []string
+	for _, query := range s.Setup {
+		setup = append(setup, sqlscan.SplitStatements(query)...)
+	}
+	s.Setup = setup
+	withSetup := func(keep []int) Scenario {
+		c := s
+		c.Setup = nil
+		for _, i := range keep {
+			c.Setup = append(c.Setup, s.Setup[i])
+		}
+		return c
+	}
+	s = withSetup(ddmin(indexes(len(s.Setup)), func(keep []int) bool {
+		return reproduces(withSetup(keep))
+	}))
+
+	// Rows.
+	for i, query := range s.Setup {
+		prefix, rows, suffix, ok := splitValues(query)
+		if !ok || len(rows) < 2 {
+			continue
+		}
+		withRows := func(keep []int) Scenario {
+			c := s
+			c.Setup = append([]string{}, s.Setup...)
+			kept := make([]string, len(keep))
+			for j, k := range keep {
+				kept[j] = rows[k]
+			}
+			c.Setup[i] = prefix + strings.Join(kept, ", ") + suffix
+			return c
+		}
+		s = withRows(ddmin(indexes(len(rows)), func(keep []int) bool {
+			return reproduces(withRows(keep))
+		}))
+	}
+
+	// Generated rows, e.g. the keys of the fuzz grammar. The upper bound is
+	// lowered first, then the lower bound raised.
+	for i := range s.Setup {
+		for j := 0; ; j++ {
+			query := s.Setup[i]
+			matches := seriesRe.FindAllStringSubmatchIndex(query, -1)
+			if j >= len(matches) {
+				break
+			}
+			m := matches[j]
+			from, _ := strconv.Atoi(query[m[2]:m[3]])
+			to, _ := strconv.Atoi(query[m[4]:m[5]])
+			withBounds := func(from, to int) Scenario {
+				c := s
+				c.Setup = append([]string{}, s.Setup...)
+				c.Setup[i] = query[:m[2]] + strconv.Itoa(from) + query[m[3]:m[4]] + strconv.Itoa(to) + query[m[5]:]
+				return c
+			}
+			to = smallestPassing(from, to, func(to int) bool {
+				return reproduces(withBounds(from, to))
+			})
+			from = to - smallestPassing(0, to-from, func(n int) bool {
+				return reproduces(withBounds(to-n, to))
+			})
+			s = withBounds(from, to)
+		}
+	}
+	return renumberSessions(s)
+}
+
+// seriesRe matches a generate_series call with integer literal bounds.
+var seriesRe = regexp.MustCompile(`(?i)\bgenerate_series\(\s*(-?\d+)\s*,\s*(-?\d+)\s*\)`)
+
+// smallestPassing returns the smallest n from lo to hi for which test holds,
+// by bisection. test must hold for hi, and is assumed to hold for every n
+// above the smallest one.
+func smallestPassing(lo, hi int, test func(int) bool) int {
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if test(mid) {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return hi
+}
+
+// ddmin is the delta debugging minimization algorithm: it returns a 1-minimal
+// subset of items for which test holds, removing no single item keeps the
+// test holding. test must hold for items.
+func ddmin(items []int, test func([]int) bool) []int {
+	n := 2
+	for len(items) >= 2 {
+		chunks := splitChunks(items, n)
+		reduced := false
+		for _, c := range chunks {
+			if test(c) {
+				items, n, reduced = c, 2, true
+				break
+			}
+		}
+		if !reduced {
+			for i := range chunks {
+				complement := complementOf(chunks, i)
+				if test(complement) {
+					items, reduced = complement, true
+					if n > 2 {
+						n--
+					}
+					break
+				}
+			}
+		}
+		if !reduced {
+			if n >= len(items) {
+				break
+			}
+			n *= 2
+			if n > len(items) {
+				n = len(items)
+			}
+		}
+	}
+	if len(items) == 1 && test(nil) {
+		return nil
+	}
+	return items
+}
+
+func splitChunks(items []int, n int) [][]int {
+	var chunks [][]int
+	start := 0
+	for i := 0; i < n; i++ {
+		end := start + (len(items)-start)/(n-i)
+		chunks = append(chunks, items[start:end])
+		start = end
+	}
+	return chunks
+}
+
+func complementOf(chunks [][]int, skip int) []int {
+	var items []int
+	for i, c := range chunks {
+		if i != skip {
+			items = append(items, c...)
+		}
+	}
+	return items
+}
+
+func indexes(n int) []int {
+	items := make([]int, n)
+	for i := range items {
+		items[i] = i
+	}
+	return items
+}
+
+func containsInt(items []int, item int) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// renumberSessions numbers the sessions left after shrinking from 0 in the
+// order of their first step.
+func renumberSessions(s Scenario) Scenario {
+	numbers := map[int]int{}
+	for _, st := range s.Steps {
+		if _, ok := numbers[st.Session]; !ok {
+			numbers[st.Session] = len(numbers)
+		}
+	}
+	steps := make([]Step, len(s.Steps))
+	for i, st := range s.Steps {
+		steps[i] = Step{numbers[st.Session], st.SQL}
+	}
+	s.Steps = steps
+	return s
+}
+
+var valuesRe = regexp.MustCompile(`(?is)^(INSERT\s+INTO\s+.*?\bVALUES\s*)(\(.*)$`)
+
+// splitValues splits an INSERT ... VALUES statement into the text before the
+// rows, the rows and the text after them, e.g. an ON CONFLICT clause.
+func splitValues(query string) (string, []string, string, bool) {
+	m := valuesRe.FindStringSubmatch(query)
+	if m == nil {
+		return "", nil, "", false
+	}
+	prefix, rest := m[1], m[2]
+	var rows []string
+	depth, start := 0, 0
+	for i := 0; i < len(rest); i++ {
+		switch c := rest[i]; c {
+		case '\'', '"':
+			i = sqlscan.SkipQuoted(rest, i, c)
+		case '(':
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				rows = append(rows, rest[start:i+1])
+			}
+		case ',', ' ', '\t', '\n', '\r':
+		default:
+			if depth == 0 {
+				return prefix, rows, " " + strings.TrimSpace(rest[i:]), len(rows) > 0
+			}
+		}
+	}
+	return prefix, rows, "", len(rows) > 0
+}
@@ -0,0 +1,166 @@
+package scenario
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDdmin(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []int
+		need  []int
+	}{
+		{"two of eight", indexes(8), []int{3, 7}},
+		{"first and last", indexes(10), []int{0, 9}},
+		{"one", indexes(5), []int{2}},
+		{"all", indexes(4), []int{0, 1, 2, 3}},
+		{"none", indexes(6), nil},
+	}
+	for _, tt := range tests {
+		calls := 0
+		got := ddmin(tt.items, func(keep []int) bool {
+			calls++
+			for _, n := range tt.need {
+				if !containsInt(keep, n) {
+					return false
+				}
+			}
+			return true
+		})
+		if !reflect.DeepEqual(got, tt.need) {
+			t.Errorf("%s: ddmin() = %v, want %v", tt.name, got, tt.need)
+		}
+		if calls > len(tt.items)*len(tt.items) {
+			t.Errorf("%s: %d tests for %d items", tt.name, calls, len(tt.items))
+		}
+	}
+}
+
+func TestSplitChunks(t *testing.T) {
+	tests := []struct {
+		items int
+		n     int
+		want  [][]int
+	}{
+		{4, 2, [][]int{{0, 1}, {2, 3}}},
+		{5, 2, [][]int{{0, 1}, {2, 3, 4}}},
+		{5, 3, [][]int{{0}, {1, 2}, {3, 4}}},
+		{3, 3, [][]int{{0}, {1}, {2}}},
+	}
+	for _, tt := range tests {
+		got := splitChunks(indexes(tt.items), tt.n)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitChunks(%d items, %d) = %v, want %v", tt.items, tt.n, got, tt.want)
+		}
+		if all := complementOf(got, -1); !reflect.DeepEqual(all, indexes(tt.items)) {
+			t.Errorf("chunks %v do not cover the items", got)
+		}
+	}
+}
+
+func TestSplitValues(t *testing.T) {
+	tests := []struct {
+		query  string
+		prefix string
+		rows   []string
+		suffix string
+		ok     bool
+	}{
+		{"INSERT INTO t VALUES (1, 'a'), (2, 'b')", "INSERT INTO t VALUES ", []string{"(1, 'a')", "(2, 'b')"}, "", true},
+		{"insert into t (id, v)\nvalues (1, ')'),\n(2, f(3))", "insert into t (id, v)\nvalues ", []string{"(1, ')')", "(2, f(3))"}, "", true},
+		{"INSERT INTO t VALUES (1), (2) ON CONFLICT (id) DO NOTHING", "INSERT INTO t VALUES ", []string{"(1)", "(2)"}, " ON CONFLICT (id) DO NOTHING", true},
+		{"INSERT INTO t SELECT k FROM generate_series(1, 4) k", "", nil, "", false},
+		{"UPDATE t SET v = 1", "", nil, "", false},
+	}
+	for _, tt := range tests {
+		prefix, rows, suffix, ok := splitValues(tt.query)
+		if prefix != tt.prefix || !reflect.DeepEqual(rows, tt.rows) || suffix != tt.suffix || ok != tt.ok {
+			t.Errorf("splitValues(%q) = %q, %q, %q, %v", tt.query, prefix, rows, suffix, ok)
+		}
+	}
+}
+
+func TestSmallestPassing(t *testing.T) {
+	for _, want := range []int{1, 2, 7, 10} {
+		got := smallestPassing(1, 10, func(n int) bool { return n >= want })
+		if got != want {
+			t.Errorf("smallestPassing() = %d, want %d", got, want)
+		}
+	}
+}
+
+func TestShrink(t *testing.T) {
+	s := Scenario{
+		Name: "fuzz/1",
+		Setup: []string{`CREATE TABLE accounts (id INT PRIMARY KEY, balance INT NOT NULL);
+			CREATE TABLE audit (id INT);
+			INSERT INTO accounts SELECT k, 100 FROM generate_series(1, 8) k;
+			INSERT INTO audit VALUES (1), (2), (3);`},
+		Steps: []Step{
+			{0, "BEGIN"},
+			{2, "BEGIN"},
+			{1, "BEGIN"},
+			{2, "SELECT 1"},
+			{1, "UPDATE accounts SET balance = 0 WHERE id = 3"},
+			{2, "UPDATE accounts SET balance = 0 WHERE id = 5"},
+			{0, "SELECT 1"},
+			{1, "UPDATE accounts SET balance = 0 WHERE id = 5"},
+			{2, "UPDATE accounts SET balance = 0 WHERE id = 3"},
+			{0, "COMMIT"},
+			{1, "COMMIT"},
+			{2, "COMMIT"},
+		},
+	}
+	// The fake deadlock needs the updates of sessions 1 and 2 in this order,
+	// the accounts table and the rows 3 to 5.
+	wantSteps := []string{
+		"UPDATE accounts SET balance = 0 WHERE id = 3",
+		"UPDATE accounts SET balance = 0 WHERE id = 5",
+		"UPDATE accounts SET balance = 0 WHERE id = 5",
+		"UPDATE accounts SET balance = 0 WHERE id = 3",
+	}
+	reproduces := func(c Scenario) bool {
+		var updates []string
+		for _, st := range c.Steps {
+			if strings.HasPrefix(st.SQL, "UPDATE") {
+				updates = append(updates, st.SQL)
+			}
+		}
+		if !reflect.DeepEqual(updates, wantSteps) {
+			return false
+		}
+		table, rows := false, false
+		for _, query := range c.Setup {
+			if strings.HasPrefix(query, "CREATE TABLE accounts") {
+				table = true
+			}
+			if m := seriesRe.FindStringSubmatch(query); m != nil {
+				from, _ := strconv.Atoi(m[1])
+				to, _ := strconv.Atoi(m[2])
+				rows = from <= 3 && to >= 5
+			}
+		}
+		return table && rows
+	}
+	got := shrink(s, reproduces)
+
+	want := Scenario{
+		Name: "fuzz/1",
+		Setup: []string{
+			"CREATE TABLE accounts (id INT PRIMARY KEY, balance INT NOT NULL)",
+			"INSERT INTO accounts SELECT k, 100 FROM generate_series(3, 5) k",
+		},
+		Steps: []Step{
+			{0, wantSteps[0]},
+			{1, wantSteps[1]},
+			{0, wantSteps[2]},
+			{1, wantSteps[3]},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("shrink() =\n%#v\nwant\n%#v", got, want)
+	}
+}
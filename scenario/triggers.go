@@ -0,0 +1,96 @@
+package scenario
+
+// lockCustomerTotals takes the row locks the orders_total trigger needs up
+// front, always in the same order.
+const lockCustomerTotals = `SELECT customer FROM customer_totals
+	WHERE customer IN ('a', 'b') ORDER BY customer FOR UPDATE`
+
+func init() {
+	Register(Scenario{
+		Name: "trigger/after-update",
+		Description: "AFTER UPDATE trigger maintaining per customer totals locks the " +
+			"totals rows in the opposite order of the updated orders",
+		Setup: []string{`
+		CREATE TABLE customer_totals (
+			customer TEXT PRIMARY KEY,
+			total INT NOT NULL
+		);
+		CREATE TABLE orders (
+			id INT PRIMARY KEY,
+			customer TEXT NOT NULL REFERENCES customer_totals,
+			amount INT NOT NULL
+		);
+		CREATE FUNCTION update_customer_total() RETURNS trigger AS $$
+		BEGIN
+			UPDATE customer_totals
+			SET total = total + NEW.amount - OLD.amount
+			WHERE customer = NEW.customer;
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql;
+		CREATE TRIGGER orders_total AFTER UPDATE ON orders
+		FOR EACH ROW EXECUTE PROCEDURE update_customer_total();
+		INSERT INTO customer_totals VALUES ('a', 0), ('b', 0);
+		INSERT INTO orders VALUES (1, 'a', 0), (2, 'b', 0), (3, 'b', 0), (4, 'a', 0);`,
+		},
+		Steps: []Step{
+			{0, `BEGIN`},
+			{1, `BEGIN`},
+			{0, `UPDATE orders SET amount = 10 WHERE id = 1`},
+			{1, `UPDATE orders SET amount = 10 WHERE id = 2`},
+			// Each session only updates its own orders, the trigger updates
+			// the totals of the other session's customer.
+			{0, `UPDATE orders SET amount = 10 WHERE id = 3`},
+			{1, `UPDATE orders SET amount = 10 WHERE id = 4`},
+			{0, `COMMIT`},
+			{1, `COMMIT`},
+		},
+		Fixes: []Scenario{{
+			Name: "lock-ordering",
+			Description: "both sessions lock the totals rows the trigger updates in " +
+				"customer order before updating orders",
+			Steps: []Step{
+				{0, `BEGIN`},
+				{1, `BEGIN`},
+				{0, lockCustomerTotals},
+				{1, lockCustomerTotals},
+				{0, `UPDATE orders SET amount = 10 WHERE id = 1`},
+				{1, `UPDATE orders SET amount = 10 WHERE id = 2`},
+				{0, `UPDATE orders SET amount = 10 WHERE id = 3`},
+				{1, `UPDATE orders SET amount = 10 WHERE id = 4`},
+				{0, `COMMIT`},
+				{1, `COMMIT`},
+			},
+		}},
+	})
+
+	Register(Scenario{
+		Name: "trigger/on-delete-cascade",
+		Description: "DELETE of a parent row cascades to a child row updated by " +
+			"another transaction",
+		Setup: []string{`
+		CREATE TABLE parent (
+			id INT PRIMARY KEY,
+			name TEXT
+		);
+		CREATE TABLE child (
+			id INT PRIMARY KEY,
+			parent_id INT NOT NULL REFERENCES parent ON DELETE CASCADE,
+			note TEXT
+		);
+		INSERT INTO parent VALUES (1, 'one'), (2, 'two');
+		INSERT INTO child VALUES (10, 1, ''), (20, 2, '');`,
+		},
+		Steps: []Step{
+			{0, `BEGIN`},
+			{1, `BEGIN`},
+			{0, `UPDATE child SET note = 'edited' WHERE id = 10`},
+			{1, `UPDATE parent SET name = 'renamed' WHERE id = 2`},
+			// Deletes child 10 through the foreign key action.
+			{1, `DELETE FROM parent WHERE id = 1`},
+			{0, `UPDATE parent SET name = 'edited' WHERE id = 2`},
+			{0, `COMMIT`},
+			{1, `COMMIT`},
+		},
+	})
+}
@@ -0,0 +1,28 @@
+package scenario
+
+func init() {
+	Register(Scenario{
+		Name: "two-phase/orphaned-prepared",
+		Description: "a prepared transaction nobody commits keeps its row lock, " +
+			"the waiting session is never reported as deadlocked",
+		Setup: []string{`
+		CREATE TABLE accounts (
+			id INT PRIMARY KEY,
+			balance INT NOT NULL
+		);
+		INSERT INTO accounts VALUES (1, 100), (2, 100);`,
+		},
+		Steps: []Step{
+			{0, `BEGIN`},
+			{0, `UPDATE accounts SET balance = balance - 10 WHERE id = 1`},
+			// The transaction is detached from the session, which is free to
+			// run other statements while the lock is still held. GIDs are
+			// unique on the server, concurrent runs use their database.
+			{0, `PREPARE TRANSACTION '` + DatabaseVar + `'`},
+			{0, `SELECT gid FROM pg_prepared_xacts WHERE database = current_database()`},
+			{1, `UPDATE accounts SET balance = balance + 10 WHERE id = 1`},
+		},
+		// A prepared transaction also prevents dropping the database.
+		Cleanup: []string{`ROLLBACK PREPARED '` + DatabaseVar + `'`},
+	})
+}
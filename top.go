@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/13rac1/pg-deadlocks/deadlockreport"
+	"github.com/13rac1/pg-deadlocks/lockmon"
+)
+
+const topHelp = "c PID cancels the query of a backend, t PID terminates it, i DURATION sets the interval, q quits"
+
+// top redraws the sessions and lock waits of the server at dsn every
+// interval, until q is typed. Commands are read a line at a time from stdin.
+// The metrics of the server are served on metricsAddr unless it is empty.
+func top(dsn string, interval time.Duration, metricsAddr string) error {
+	ctx := context.Background()
+	db, err := sqlx.Connect("postgres", dsn)
+	if err != nil {
+		return fmt.Errorf("unable to connect: %w", err)
+	}
+	defer db.Close()
+	version, err := lockmon.ServerVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if metricsAddr != "" {
+		err = serveMetrics(ctx, db, metricsAddr)
+		if err != nil {
+			return err
+		}
+	}
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	status := topHelp
+	timer := time.NewTimer(0)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				// Without stdin there are no commands, keep refreshing.
+				lines = nil
+				continue
+			}
+			var quit bool
+			status, quit = topCommand(ctx, db, line, &interval)
+			if quit {
+				return nil
+			}
+		case <-timer.C:
+			timer.Reset(interval)
+		}
+		sessions, err := lockmon.SampleSessions(ctx, db, version)
+		if err != nil {
+			return err
+		}
+		graph, err := lockmon.SampleLockGraph(ctx, db)
+		if err != nil {
+			return err
+		}
+		// Clear the screen and move the cursor to the top left.
+		fmt.Print("\033[H\033[2J")
+		fmt.Printf("pg-deadlocks top, server %d, every %s, %s\n", version, interval, time.Now().Format("15:04:05"))
+		deadlockreport.WriteTop(os.Stdout, sessions, graph, terminalWidth())
+		fmt.Printf("\n%s\n> ", status)
+	}
+}
+
+// topCommand runs a command typed in top and returns the status line to
+// show, and whether to quit.
+func topCommand(ctx context.Context, db *sqlx.DB, line string, interval *time.Duration) (string, bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return topHelp, false
+	}
+	if fields[0] == "q" {
+		return "", true
+	}
+	if len(fields) != 2 {
+		return "unknown command, " + topHelp, false
+	}
+	switch fields[0] {
+	case "c", "t":
+		pid, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return fmt.Sprintf("invalid pid %q", fields[1]), false
+		}
+		if fields[0] == "c" {
+			err = lockmon.CancelBackend(ctx, db, pid)
+		} else {
+			err = lockmon.TerminateBackend(ctx, db, pid)
+		}
+		if err != nil {
+			return err.Error(), false
+		}
+		if fields[0] == "c" {
+			return fmt.Sprintf("cancelled the query of %d", pid), false
+		}
+		return fmt.Sprintf("terminated %d", pid), false
+	case "i":
+		d, err := time.ParseDuration(fields[1])
+		if err != nil || d <= 0 {
+			return fmt.Sprintf("invalid interval %q, e.g. 500ms or 2s", fields[1]), false
+		}
+		*interval = d
+		return fmt.Sprintf("refreshing every %s", d), false
+	}
+	return "unknown command, " + topHelp, false
+}
+
+// terminalWidth returns the width of the terminal from $COLUMNS, which shells
+// set but do not always export.
+func terminalWidth() int {
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		return columns
+	}
+	return 160
+}
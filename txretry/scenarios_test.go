@@ -0,0 +1,43 @@
+package txretry_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/13rac1/pg-deadlocks/deadlocktest"
+	"github.com/13rac1/pg-deadlocks/pgcontainer"
+	"github.com/13rac1/pg-deadlocks/scenario"
+)
+
+// TestRetryScenarios runs the retry/ scenarios, in which the first attempt of
+// a transaction deadlocks or fails to serialize and RunInTx retries it. It is
+// skipped when Docker is not available.
+func TestRetryScenarios(t *testing.T) {
+	srv := deadlocktest.Start(t, pgcontainer.Config{})
+	scenarios, err := scenario.Match("retry/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scenarios) == 0 {
+		t.Fatal("no retry/ scenarios registered")
+	}
+	for _, s := range scenarios {
+		s := s
+		t.Run(s.Name, func(t *testing.T) {
+			r := srv.ExpectNoDeadlock(t, s)
+			if r.Outcome != scenario.OutcomeOK {
+				t.Errorf("outcome %s, want ok", r.Outcome)
+			}
+			retried := false
+			for _, st := range r.Steps {
+				if st.Err != nil {
+					t.Errorf("%s: %s", st.SQL, st.Err)
+				}
+				retried = retried || strings.Contains(st.SQL, "retried")
+			}
+			if !retried {
+				t.Error("no transaction was retried")
+			}
+		})
+	}
+}
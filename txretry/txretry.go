@@ -0,0 +1,147 @@
+// Package txretry runs transactions which are retried when Postgres aborts
+// them with deadlock_detected or serialization_failure.
+package txretry
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Options configure RunInTx. The zero value retries up to DefaultMaxAttempts
+// times with delays from DefaultBaseDelay up to DefaultMaxDelay.
+type Options struct {
+	// TxOptions are passed to BeginTxx, e.g. the isolation level.
+	TxOptions *sql.TxOptions
+	// MaxAttempts is the number of attempts including the first one.
+	MaxAttempts int
+	// BaseDelay is the upper bound of the delay before the first retry, it
+	// doubles with every retry up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Retryable decides which errors are retried, by default Retryable.
+	Retryable func(error) bool
+	// OnAttempt is called after every attempt, e.g. to count retries.
+	OnAttempt func(Attempt)
+}
+
+const (
+	DefaultMaxAttempts = 5
+	DefaultBaseDelay   = 10 * time.Millisecond
+	DefaultMaxDelay    = time.Second
+)
+
+// Attempt describes a finished attempt. Err is nil when the transaction
+// committed, Delay is the wait before the next attempt when Retry is set.
+type Attempt struct {
+	Number   int
+	Err      error
+	Duration time.Duration
+	Retry    bool
+	Delay    time.Duration
+}
+
+// ErrIgnoredFailure is returned when fn returned nil although a statement of
+// the transaction failed. Postgres aborts the transaction on the first error
+// and turns its COMMIT into a ROLLBACK, so nothing was committed.
+var ErrIgnoredFailure = errors.New("transaction failed but fn returned no error, it was rolled back")
+
+// Retryable reports whether err is a deadlock_detected (40P01) or
+// serialization_failure (40001) error. Postgres rolled back the whole
+// transaction, it can be run again from the start.
+func Retryable(err error) bool {
+	var errPq *pq.Error
+	if !errors.As(err, &errPq) {
+		return false
+	}
+	return errPq.Code == "40P01" || errPq.Code == "40001"
+}
+
+// RunInTx runs fn in a transaction and commits it. When fn or the commit
+// fails with a retryable error the transaction is rolled back and fn runs
+// again in a new transaction after a jittered exponential backoff. fn must
+// return the errors of its statements, after a failed statement the
+// transaction is aborted and every further statement fails until it is
+// rolled back.
+func RunInTx(ctx context.Context, db *sqlx.DB, opts Options, fn func(ctx context.Context, tx *sqlx.Tx) error) error {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultMaxAttempts
+	}
+	if opts.BaseDelay <= 0 {
+		opts.BaseDelay = DefaultBaseDelay
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = DefaultMaxDelay
+	}
+	if opts.Retryable == nil {
+		opts.Retryable = Retryable
+	}
+
+	for n := 1; ; n++ {
+		start := time.Now()
+		err := attempt(ctx, db, opts.TxOptions, fn)
+		a := Attempt{Number: n, Err: err, Duration: time.Since(start)}
+		a.Retry = err != nil && n < opts.MaxAttempts && opts.Retryable(err)
+		if a.Retry {
+			a.Delay = backoff(opts.BaseDelay, opts.MaxDelay, n)
+		}
+		if opts.OnAttempt != nil {
+			opts.OnAttempt(a)
+		}
+		if !a.Retry {
+			if err != nil && n > 1 {
+				return fmt.Errorf("attempt %d of %d: %w", n, opts.MaxAttempts, err)
+			}
+			return err
+		}
+
+		t := time.NewTimer(a.Delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// attempt runs fn in a new transaction. The transaction is rolled back
+// instead of committed when fn fails, a COMMIT of an aborted transaction
+// would hide the error.
+func attempt(ctx context.Context, db *sqlx.DB, txOptions *sql.TxOptions, fn func(ctx context.Context, tx *sqlx.Tx) error) error {
+	tx, err := db.BeginTxx(ctx, txOptions)
+	if err != nil {
+		return err
+	}
+	err = fn(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err == pq.ErrInFailedTransaction {
+		return ErrIgnoredFailure
+	}
+	// A serializable transaction can fail at commit.
+	return err
+}
+
+// backoff returns a random delay up to base doubled for every previous
+// attempt, capped at max. The full jitter spreads the retries of the
+// transactions which deadlocked with each other.
+func backoff(base, max time.Duration, n int) time.Duration {
+	d := base
+	for i := 1; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
@@ -0,0 +1,226 @@
+package txretry
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// fakeDriver counts the transactions RunInTx begins, commits and rolls back.
+// commitErr is returned by Commit.
+type fakeDriver struct {
+	mu                        sync.Mutex
+	begun, commits, rollbacks int
+	commitErr                 error
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) { return fakeConn{d}, nil }
+
+type fakeConn struct{ d *fakeDriver }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("fake driver runs no statements")
+}
+func (c fakeConn) Close() error { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) {
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	c.d.begun++
+	return fakeTx{c.d}, nil
+}
+
+type fakeTx struct{ d *fakeDriver }
+
+func (t fakeTx) Commit() error {
+	t.d.mu.Lock()
+	defer t.d.mu.Unlock()
+	t.d.commits++
+	return t.d.commitErr
+}
+
+func (t fakeTx) Rollback() error {
+	t.d.mu.Lock()
+	defer t.d.mu.Unlock()
+	t.d.rollbacks++
+	return nil
+}
+
+var driverID int
+
+func fakeDB(t *testing.T, d *fakeDriver) *sqlx.DB {
+	driverID++
+	name := fmt.Sprintf("txretry-fake-%d", driverID)
+	sql.Register(name, d)
+	db, err := sqlx.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+var (
+	deadlock             = &pq.Error{Code: "40P01", Message: "deadlock detected"}
+	serializationFailure = &pq.Error{Code: "40001", Message: "could not serialize access due to concurrent update"}
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{deadlock, true},
+		{serializationFailure, true},
+		{fmt.Errorf("transfer: %w", deadlock), true},
+		{&pq.Error{Code: "55P03", Message: "could not obtain lock"}, false},
+		{&pq.Error{Code: "23505", Message: "duplicate key value"}, false},
+		{&pq.Error{Code: "40003", Message: "statement completion unknown"}, false},
+		{&pq.Error{Code: "57014", Message: "canceling statement due to user request"}, false},
+		{errors.New("40P01"), false},
+		{ErrIgnoredFailure, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := Retryable(tt.err); got != tt.want {
+			t.Errorf("Retryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base, max := 10*time.Millisecond, 100*time.Millisecond
+	bounds := []time.Duration{10, 20, 40, 80, 100, 100, 100}
+	for i, bound := range bounds {
+		n := i + 1
+		bound *= time.Millisecond
+		var longest time.Duration
+		for j := 0; j < 1000; j++ {
+			d := backoff(base, max, n)
+			if d < 0 || d > bound {
+				t.Fatalf("backoff(%s, %s, %d) = %s, want at most %s", base, max, n, d, bound)
+			}
+			if d > longest {
+				longest = d
+			}
+		}
+		// The jitter spreads the delays over the whole range.
+		if longest < bound/2 {
+			t.Errorf("backoff(%s, %s, %d) never exceeded %s in 1000 samples", base, max, n, longest)
+		}
+	}
+}
+
+func TestRunInTxRetries(t *testing.T) {
+	d := &fakeDriver{}
+	db := fakeDB(t, d)
+	var attempts []Attempt
+	calls := 0
+	err := RunInTx(context.Background(), db, Options{
+		BaseDelay: time.Microsecond,
+		OnAttempt: func(a Attempt) { attempts = append(attempts, a) },
+	}, func(ctx context.Context, tx *sqlx.Tx) error {
+		calls++
+		if calls < 3 {
+			return deadlock
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("RunInTx() = %v, want nil", err)
+	}
+	if calls != 3 || d.begun != 3 || d.rollbacks != 2 || d.commits != 1 {
+		t.Errorf("calls %d, begun %d, rollbacks %d, commits %d, want 3, 3, 2, 1", calls, d.begun, d.rollbacks, d.commits)
+	}
+	if len(attempts) != 3 {
+		t.Fatalf("OnAttempt called %d times, want 3", len(attempts))
+	}
+	for i, a := range attempts {
+		retry := i < 2
+		if a.Number != i+1 || a.Retry != retry || (a.Err != nil) != retry {
+			t.Errorf("attempt %d = %+v", i+1, a)
+		}
+		if !retry && a.Delay != 0 {
+			t.Errorf("attempt %d has delay %s without a retry", i+1, a.Delay)
+		}
+	}
+}
+
+func TestRunInTxGivesUp(t *testing.T) {
+	d := &fakeDriver{}
+	db := fakeDB(t, d)
+	calls := 0
+	err := RunInTx(context.Background(), db, Options{MaxAttempts: 3, BaseDelay: time.Microsecond}, func(ctx context.Context, tx *sqlx.Tx) error {
+		calls++
+		return serializationFailure
+	})
+	if calls != 3 {
+		t.Errorf("fn called %d times, want 3", calls)
+	}
+	if !errors.Is(err, serializationFailure) || err.Error() != "attempt 3 of 3: pq: "+serializationFailure.Message {
+		t.Errorf("RunInTx() = %v, want the last error of attempt 3", err)
+	}
+}
+
+func TestRunInTxDoesNotRetry(t *testing.T) {
+	unique := &pq.Error{Code: "23505", Message: "duplicate key value"}
+	tests := []struct {
+		name      string
+		opts      Options
+		err       error
+		wantCalls int
+	}{
+		{"other error", Options{}, unique, 1},
+		{"custom retryable", Options{Retryable: func(err error) bool { return err == unique }, MaxAttempts: 2}, unique, 2},
+		{"custom not retryable", Options{Retryable: func(error) bool { return false }}, deadlock, 1},
+	}
+	for _, tt := range tests {
+		db := fakeDB(t, &fakeDriver{})
+		tt.opts.BaseDelay = time.Microsecond
+		calls := 0
+		err := RunInTx(context.Background(), db, tt.opts, func(ctx context.Context, tx *sqlx.Tx) error {
+			calls++
+			return tt.err
+		})
+		if calls != tt.wantCalls || !errors.Is(err, tt.err) {
+			t.Errorf("%s: %d calls, error %v, want %d calls and %v", tt.name, calls, err, tt.wantCalls, tt.err)
+		}
+	}
+}
+
+func TestRunInTxIgnoredFailure(t *testing.T) {
+	d := &fakeDriver{commitErr: pq.ErrInFailedTransaction}
+	db := fakeDB(t, d)
+	calls := 0
+	err := RunInTx(context.Background(), db, Options{}, func(ctx context.Context, tx *sqlx.Tx) error {
+		calls++
+		return nil
+	})
+	if err != ErrIgnoredFailure {
+		t.Errorf("RunInTx() = %v, want ErrIgnoredFailure", err)
+	}
+	if calls != 1 {
+		t.Errorf("fn called %d times, want 1", calls)
+	}
+}
+
+func TestRunInTxCancelled(t *testing.T) {
+	db := fakeDB(t, &fakeDriver{})
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := RunInTx(ctx, db, Options{BaseDelay: time.Hour, MaxDelay: time.Hour}, func(ctx context.Context, tx *sqlx.Tx) error {
+		calls++
+		cancel()
+		return deadlock
+	})
+	if err != context.Canceled || calls != 1 {
+		t.Errorf("RunInTx() = %v after %d calls, want context.Canceled after 1", err, calls)
+	}
+}